@@ -3,8 +3,11 @@ package oasis
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 // AuthorizeStage represents the stage of process
@@ -84,10 +87,20 @@ type AuthorizeRequest struct {
 	// as described by RFC6749 section 3.1.2
 	RedirectURI string `json:"redirect_uri,omitempty"`
 
+	// RedirectURIDefaulted. Library specific parameter to mark that
+	// redirect_uri is not in the request, but filled in from the
+	// only registered one of the client.
+	RedirectURIDefaulted bool `json:"redirect_uri_defaulted,omitempty"`
+
 	// Scope. OPTIONAL. The scope of access request as described by
 	// RFC6749 section 3.3
 	Scope string `json:"scope,omitempty"`
 
+	// RequestedScope. Library specific parameter to store the
+	// scope originally requested by the client, if Scope has
+	// been narrowed down to the granted scope.
+	RequestedScope string `json:"requested_scope,omitempty"`
+
 	// State. RECOMMENDED. An opaque value used by the client to
 	// maintain state between the request and callback.
 	//
@@ -97,6 +110,44 @@ type AuthorizeRequest struct {
 	// in RFC6749 Section 10.12.
 	State string `json:"state,omitempty"`
 
+	// CodeChallenge. OPTIONAL. The PKCE code challenge derived
+	// from the code verifier as described in RFC7636 section 4.2.
+	CodeChallenge string `json:"code_challenge,omitempty"`
+
+	// CodeChallengeMethod. OPTIONAL. The method used to derive
+	// CodeChallenge, either "plain" or "S256". Defaults to "plain"
+	// if CodeChallenge is set (RFC7636 section 4.3).
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+
+	// Nonce. OPTIONAL. A string value used to associate a client
+	// session with an ID Token, and to mitigate replay attacks
+	// (OpenID Connect Core 1.0 section 3.1.2.1).
+	Nonce string `json:"nonce,omitempty"`
+
+	// Prompt. OPTIONAL. Space delimited list of "none", "login",
+	// "consent" and "select_account", to specify whether the
+	// user is prompted for reauthentication and consent.
+	Prompt string `json:"prompt,omitempty"`
+
+	// MaxAge. OPTIONAL. The allowable elapsed time in seconds
+	// since the last time the user was authenticated. Nil
+	// if not requested (see AuthTimeAllowed).
+	MaxAge *int `json:"max_age,omitempty"`
+
+	// ACRValues. OPTIONAL. Space delimited list of requested
+	// Authentication Context Class Reference values. It is only
+	// a hint to the handlers, and not enforced by the library.
+	ACRValues string `json:"acr_values,omitempty"`
+
+	// LoginHint. OPTIONAL. Hint about the login identifier
+	// the user might use to log in.
+	LoginHint string `json:"login_hint,omitempty"`
+
+	// IDTokenHint. OPTIONAL. ID Token previously issued, as a
+	// hint about the current authenticated session of the user.
+	// It is neither verified nor enforced by the library.
+	IDTokenHint string `json:"id_token_hint,omitempty"`
+
 	// Stage. Library specific parameter to determine
 	// the authorization stage.
 	Stage AuthorizeStage `json:"stage,omitempty"`
@@ -104,6 +155,35 @@ type AuthorizeRequest struct {
 	// UserID. Library specific parameter to store
 	// the id of successfully authenticated user.
 	UserID string `json:"user_id,omitempty"`
+
+	// AuthTime. Library specific parameter to store the
+	// time the user is authenticated, if known.
+	AuthTime *time.Time `json:"auth_time,omitempty"`
+
+	// RequestedAt. Library specific parameter to store the
+	// time the authorization request is received, if MaxAge
+	// is requested.
+	RequestedAt *time.Time `json:"requested_at,omitempty"`
+
+	// OTPEnrollSecret. Library specific parameter to store
+	// the base32 TOTP secret the user is enrolling (see
+	// TOTPHandler), until it is confirmed.
+	OTPEnrollSecret string `json:"otp_enroll_secret,omitempty"`
+
+	// OTPFailures. Library specific parameter to store the
+	// number of wrong codes entered (see TOTPHandler).
+	OTPFailures int `json:"otp_failures,omitempty"`
+
+	// PendingHandle. Library specific parameter to store
+	// the handle of the request in PendingAuthorizationStore,
+	// if the request is restored from there.
+	PendingHandle string `json:"-"`
+
+	// SealedStateID. Library specific parameter to store
+	// the unique id of the authorization, which is kept by all
+	// its sealed values (see StateSealer), if the request is
+	// sealed or restored from there.
+	SealedStateID string `json:"-"`
 }
 
 // AuthorizeDecoder decodes an http request as
@@ -123,6 +203,39 @@ type DefaultAuthorizeDecoder struct {
 // RFC6749 standard. An error will be returned response_type
 // is not provided or is not in allowedResponseTypes array.
 //
+// If Context.ClientStore is set in the request context, unknown
+// client_id is rejected, and redirect_uri must exactly match
+// one of the registered redirect URIs. If redirect_uri is not
+// provided, the only registered one is filled in.
+//
+// The scope is parsed as described in RFC6749 section 3.3, and
+// narrowed down to the scope granted by Context.ScopeValidator (or
+// DefaultScopeValidator). If narrowed down, the requested scope
+// is kept in RequestedScope.
+//
+// The parameters of OpenID Connect Authentication Request (i.e.
+// nonce, prompt, max_age, acr_values, login_hint and id_token_hint)
+// are decoded too. Invalid prompt or max_age is an error.
+//
+// If Context.DisableImplicitGrant is set in the request context,
+// response_type "token" is rejected as unsupported_response_type.
+//
+// For response_type "code", the PKCE parameters are validated
+// (RFC7636 section 4.4) against the Context.PKCEPolicy of the
+// client, if set in the request context.
+//
+// If Context.PendingAuthorizationStore is set in the request
+// context, and the request carries a handle in the cookie (see
+// PendingHandleParam), the pending request is restored with its Stage
+// and UserID instead. Likewise, if Context.StateSealer is set and the
+// request carries a sealed AuthorizeRequest in the cookie (see
+// SealedStateParam), the sealed request is restored. The cookie is
+// ignored by a new authorization request, i.e. with response_type
+// in the query.
+//
+// The error returned, if any, is an *Error with the error
+// code described in RFC6749 section 4.1.2.1.
+//
 // An *AuthorizeRequest is always returned even if
 // there is an error.
 func (ad *DefaultAuthorizeDecoder) DecodeAuthorize(r *http.Request) (ctx context.Context, ar *AuthorizeRequest, err error) {
@@ -130,24 +243,134 @@ func (ad *DefaultAuthorizeDecoder) DecodeAuthorize(r *http.Request) (ctx context
 	// inherit the context from request
 	ctx = r.Context()
 
+	// restore the request of later stages, if pending
+	// on server side or sealed
+	if actx := GetContext(ctx); actx != nil && actx.PendingAuthorizationStore != nil {
+		if handle := carrierValue(r, PendingHandleParam); handle != "" {
+			return ad.decodePending(ctx, r, actx.PendingAuthorizationStore, handle)
+		}
+	}
+	if actx := GetContext(ctx); actx != nil && actx.StateSealer != nil {
+		if sealed := carrierValue(r, SealedStateParam); sealed != "" {
+			return ad.decodeSealed(ctx, r, actx.StateSealer, sealed)
+		}
+	}
+
 	// construct authorize request as specified
 	// in RFC.
 	ar = &AuthorizeRequest{
+		HTTPRequest:  r,
 		ResponseType: strings.Trim(r.URL.Query().Get("response_type"), "\r\n\t "),
 		ClientID:     strings.Trim(r.URL.Query().Get("client_id"), "\r\n\t "),
 		RedirectURI:  strings.Trim(r.URL.Query().Get("redirect_uri"), "\r\n\t "),
 		Scope:        strings.Trim(r.URL.Query().Get("scope"), "\r\n\t "),
 		State:        strings.Trim(r.URL.Query().Get("state"), "\r\n\t "),
+
+		CodeChallenge:       strings.Trim(r.URL.Query().Get("code_challenge"), "\r\n\t "),
+		CodeChallengeMethod: strings.Trim(r.URL.Query().Get("code_challenge_method"), "\r\n\t "),
+	}
+
+	if oerr := ad.validate(ctx, ar); oerr != nil {
+		err = oerr
+	} else if oerr = decodeOIDC(ar, r.URL.Query()); oerr != nil {
+		err = oerr
+	}
+	return
+}
+
+// decodeSealed restores the AuthorizeRequest, includes its Stage
+// and UserID, from the sealed value and validates it again.
+func (ad *DefaultAuthorizeDecoder) decodeSealed(ctx context.Context, r *http.Request, sealer StateSealer, sealed string) (context.Context, *AuthorizeRequest, error) {
+	ar, err := sealer.Open(sealed)
+	switch err {
+	case nil:
+	case ErrSealedStateExpired:
+		return ctx, &AuthorizeRequest{HTTPRequest: r}, NewError(ErrInvalidRequest, "authorization session has expired")
+	default:
+		return ctx, &AuthorizeRequest{HTTPRequest: r}, NewError(ErrInvalidRequest, "authorization session is invalid")
+	}
+
+	ar.HTTPRequest = r
+	if oerr := ad.validate(ctx, ar); oerr != nil {
+		return ctx, ar, oerr
+	}
+	return ctx, ar, nil
+}
+
+// decodePending restores the AuthorizeRequest, includes its Stage
+// and UserID, from the PendingAuthorizationStore and validates
+// it again.
+func (ad *DefaultAuthorizeDecoder) decodePending(ctx context.Context, r *http.Request, store PendingAuthorizationStore, handle string) (context.Context, *AuthorizeRequest, error) {
+	ar, err := store.GetPending(ctx, handle)
+	switch err {
+	case nil:
+	case ErrPendingNotFound:
+		return ctx, &AuthorizeRequest{HTTPRequest: r}, NewError(ErrInvalidRequest, "authorization session has expired")
+	default:
+		return ctx, &AuthorizeRequest{HTTPRequest: r}, NewError(ErrServerError, "unable to retrieve authorization session")
+	}
+
+	ar.HTTPRequest = r
+	ar.PendingHandle = handle
+	if oerr := ad.validate(ctx, ar); oerr != nil {
+		return ctx, ar, oerr
+	}
+	return ctx, ar, nil
+}
+
+// carrierValue returns the value of the named carrier cookie, unless
+// r is a new authorization request. The carrier is never read from
+// the query or the form (see carrierCookie).
+func carrierValue(r *http.Request, name string) string {
+	if r.URL.Query().Get("response_type") != "" {
+		return ""
+	}
+	if cookie, err := r.Cookie(name); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// validate validates the decoded AuthorizeRequest.
+func (ad *DefaultAuthorizeDecoder) validate(ctx context.Context, ar *AuthorizeRequest) *Error {
+
+	// without a valid client_id and redirect_uri, the error
+	// must not be redirected to the client (RFC6749 section 4.1.2.1)
+	if ar.ClientID == "" {
+		return NewError(ErrInvalidRequest, "client_id is required but not set")
+	}
+	client := &Client{ID: ar.ClientID}
+	if actx := GetContext(ctx); actx != nil && actx.ClientStore != nil {
+		var oerr *Error
+		if client, oerr = validateClient(ctx, actx.ClientStore, ar); oerr != nil {
+			return oerr
+		}
 	}
 
 	if ar.ResponseType == "" {
-		err = fmt.Errorf("response_type is required but not set")
-		return
+		return NewError(ErrInvalidRequest, "response_type is required but not set")
+	}
+	if actx := GetContext(ctx); actx != nil && actx.DisableImplicitGrant && ar.ResponseType == "token" {
+		return NewError(ErrUnsupportedResponseType, "implicit grant is disabled")
 	}
 	if _, ok := ad.allowedResponseTypes[ar.ResponseType]; !ok {
-		err = fmt.Errorf(`response_type "%s" is not allowed`, ar.ResponseType)
+		return NewError(ErrUnsupportedResponseType, fmt.Sprintf(`response_type "%s" is not allowed`, ar.ResponseType))
 	}
-	return
+	if !client.AllowsResponseType(ar.ResponseType) {
+		return NewError(ErrUnauthorizedClient, fmt.Sprintf(`response_type "%s" is not allowed for the client`, ar.ResponseType))
+	}
+	if oerr := validateScope(ctx, client, ar); oerr != nil {
+		return oerr
+	}
+	if ar.ResponseType == "code" {
+		return validatePKCE(ar, getPKCEPolicy(ctx, client))
+	}
+	return nil
+}
+
+// ResponseTypes returns the allowed response_type values, sorted.
+func (ad *DefaultAuthorizeDecoder) ResponseTypes() []string {
+	return sortedKeys(ad.allowedResponseTypes)
 }
 
 // NewAuthorizeDecoder returns the default AuthorizeDecoder implementation
@@ -206,17 +429,69 @@ func (f AuthorizeHandlerFunc) HandleAuthorizeRequest(ctx context.Context, ar *Au
 	return f(ctx, ar, decodeErr)
 }
 
+// AuthorizeMiddleware wraps an AuthorizeHandler with
+// shared behavior.
+type AuthorizeMiddleware func(AuthorizeHandler) AuthorizeHandler
+
 // AuthorizeHandlerMux route different stage of AuthorizeRequest
 // to different AuthorizeHandler.
+//
+// The mux may also enforce rules on the stages. Before calling the
+// handler, the request must fulfill all the entry conditions of its
+// stage (see Require). After the handler, the request may only move
+// to the declared next stages (see Allow). A rejected request gets
+// a *StageError.
+//
+// It is safe to configure the mux while it is serving requests.
 type AuthorizeHandlerMux struct {
-	handlers map[AuthorizeStage]AuthorizeHandler
+	mutex       sync.RWMutex
+	handlers    map[AuthorizeStage]AuthorizeHandler
+	transitions map[AuthorizeStage]map[AuthorizeStage]bool
+	conditions  map[AuthorizeStage][]StageCondition
+	middlewares []AuthorizeMiddleware
+	notFound    AuthorizeHandler
+
+	// chains are the handlers composed with the stage rules and
+	// middlewares, rebuilt whenever the mux is configured.
+	chains        map[AuthorizeStage]AuthorizeHandler
+	notFoundChain AuthorizeHandler
 }
 
 // NewAuthorizeHandlerMux returns an initialized *AuthorizeHandlerMux
 func NewAuthorizeHandlerMux() *AuthorizeHandlerMux {
-	return &AuthorizeHandlerMux{
-		handlers: make(map[AuthorizeStage]AuthorizeHandler),
+	mux := &AuthorizeHandlerMux{
+		handlers:    make(map[AuthorizeStage]AuthorizeHandler),
+		transitions: make(map[AuthorizeStage]map[AuthorizeStage]bool),
+		conditions:  make(map[AuthorizeStage][]StageCondition),
+	}
+	mux.compose()
+	return mux
+}
+
+// compose builds the handler chain of every stage, and of the
+// NotFound handler. The caller must hold the write lock.
+func (mux *AuthorizeHandlerMux) compose() {
+	wrap := func(handler AuthorizeHandler) AuthorizeHandler {
+		for i := len(mux.middlewares) - 1; i >= 0; i-- {
+			handler = mux.middlewares[i](handler)
+		}
+		return handler
+	}
+
+	chains := make(map[AuthorizeStage]AuthorizeHandler, len(mux.handlers))
+	for stage, handler := range mux.handlers {
+		chains[stage] = wrap(&stageHandler{
+			handler:    handler,
+			conditions: mux.conditions[stage],
+			allowed:    mux.transitions[stage],
+		})
 	}
+	notFound := mux.notFound
+	if notFound == nil {
+		notFound = AuthorizeHandlerFunc(stageNotFound)
+	}
+	mux.chains = chains
+	mux.notFoundChain = wrap(notFound)
 }
 
 // Add a handler to handle specific stage.
@@ -224,7 +499,10 @@ func NewAuthorizeHandlerMux() *AuthorizeHandlerMux {
 // If 2 handlers are added to the same stage, the later one will
 // overwrite the former one.
 func (mux *AuthorizeHandlerMux) Add(stage AuthorizeStage, handler AuthorizeHandler) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
 	mux.handlers[stage] = handler
+	mux.compose()
 }
 
 // AddFunc add a function, as handler, to handle specific stage.
@@ -232,14 +510,116 @@ func (mux *AuthorizeHandlerMux) Add(stage AuthorizeStage, handler AuthorizeHandl
 // If 2 handlers are added to the same stage, the later one will
 // overwrite the former one.
 func (mux *AuthorizeHandlerMux) AddFunc(stage AuthorizeStage, handler AuthorizeHandlerFunc) {
-	mux.handlers[stage] = handler
+	mux.Add(stage, handler)
+}
+
+// Use appends middlewares to wrap the handlers of every stage,
+// includes the NotFound handler. The first middleware is the
+// outermost one.
+//
+// Middlewares see the request before the stage rules are
+// enforced, and the response of any rejection.
+//
+// The handler chains are composed when the mux is configured,
+// not on every request, so a middleware is called to wrap each
+// handler again only after the mux configuration changes.
+func (mux *AuthorizeHandlerMux) Use(middlewares ...AuthorizeMiddleware) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	mux.middlewares = append(mux.middlewares, middlewares...)
+	mux.compose()
+}
+
+// SetNotFound sets the handler for requests of stages
+// without handler. If not set, such requests get an
+// Internal Server Error.
+func (mux *AuthorizeHandlerMux) SetNotFound(handler AuthorizeHandler) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	mux.notFound = handler
+	mux.compose()
+}
+
+// Allow declares the stages a request may move to from stage.
+//
+// Once any transition is declared from a stage, the handler of the
+// stage may only move the request to the declared ones (or leave it
+// at the same stage). Stages without any declared transition are not
+// restricted.
+func (mux *AuthorizeHandlerMux) Allow(from AuthorizeStage, to ...AuthorizeStage) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+
+	// copy on write, as the map may be in use by
+	// requests being served
+	allowed := make(map[AuthorizeStage]bool)
+	for stage := range mux.transitions[from] {
+		allowed[stage] = true
+	}
+	for _, stage := range to {
+		allowed[stage] = true
+	}
+	mux.transitions[from] = allowed
+	mux.compose()
+}
+
+// Require adds entry conditions to stage. A request at the stage
+// must fulfill all of them before its handler is called.
+//
+// For example, to reject forged request that jumps to
+// StageToAuthorize without a user:
+//
+//	mux.Require(oasis.StageToAuthorize, oasis.RequireUser)
+func (mux *AuthorizeHandlerMux) Require(stage AuthorizeStage, conditions ...StageCondition) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	mux.conditions[stage] = append(mux.conditions[stage][:len(mux.conditions[stage]):len(mux.conditions[stage])], conditions...)
+	mux.compose()
 }
 
 // HandleAuthorizeRequest implements AuthorizeRequestHandler
 func (mux *AuthorizeHandlerMux) HandleAuthorizeRequest(ctx context.Context, ar *AuthorizeRequest, decodeErr error) (rd Responder) {
-	if handler, ok := mux.handlers[ar.Stage]; ok {
-		return handler.HandleAuthorizeRequest(ctx, ar, decodeErr)
+	mux.mutex.RLock()
+	handler, ok := mux.chains[ar.Stage]
+	if !ok {
+		handler = mux.notFoundChain
+	}
+	mux.mutex.RUnlock()
+
+	return handler.HandleAuthorizeRequest(ctx, ar, decodeErr)
+}
+
+// stageHandler enforces the stage rules around the
+// handler of a stage.
+type stageHandler struct {
+	handler    AuthorizeHandler
+	conditions []StageCondition
+	allowed    map[AuthorizeStage]bool
+}
+
+// HandleAuthorizeRequest implements AuthorizeHandler
+func (sh *stageHandler) HandleAuthorizeRequest(ctx context.Context, ar *AuthorizeRequest, decodeErr error) (rd Responder) {
+	from := ar.Stage
+	for _, condition := range sh.conditions {
+		if err := condition(ctx, ar); err != nil {
+			return &stageErrorResponse{err: &StageError{From: from, To: from, Reason: err}}
+		}
 	}
+
+	// let the handler check the transition before it is persisted,
+	// and check again after for handlers which do not
+	if ctx != nil && sh.allowed != nil {
+		ctx = withStageTransitions(ctx, from, sh.allowed)
+	}
+	rd = sh.handler.HandleAuthorizeRequest(ctx, ar, decodeErr)
+	if sh.allowed != nil && ar.Stage != from && !sh.allowed[ar.Stage] {
+		return &stageErrorResponse{err: &StageError{From: from, To: ar.Stage, Reason: errTransitionNotAllowed}}
+	}
+	return
+}
+
+// stageNotFound is the default handler of stages without handler.
+func stageNotFound(ctx context.Context, ar *AuthorizeRequest, decodeErr error) Responder {
 	return &ResponseCache{
 		Code:        http.StatusInternalServerError,
 		HeaderCache: make(http.Header),
@@ -255,10 +635,15 @@ func NewAuthorizeEndpoint(
 	handler AuthorizeHandler,
 	encoder ResponseEncoder,
 ) http.Handler {
+	actx = actx.withDefaults()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := WithContext(r.Context(), &actx)
 		ctx, ar, decodeErr := decoder.DecodeAuthorize(r.WithContext(ctx))
 		rspr := handler.HandleAuthorizeRequest(ctx, ar, decodeErr)
-		encoder.EncodeResponse(w, rspr)
+		encodeAndLog(ctx, actx.Logger, "authorize request", w, r, encoder, rspr, decodeErr,
+			slog.String("client_id", ar.ClientID),
+			slog.String("response_type", ar.ResponseType),
+			slog.Int("stage", int(ar.Stage)),
+		)
 	})
 }
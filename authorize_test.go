@@ -60,7 +60,7 @@ func TestAuthorizeDecoder_DecodeAuthorize(t *testing.T) {
 				"response_type": {"token"},
 				"client_id":     {"dummy-client"},
 			},
-			expectedError: `response_type "token" is not allowed`,
+			expectedError: `unsupported_response_type: response_type "token" is not allowed`,
 		},
 		{
 			decoderDesc: `allow only "token" response_type`,
@@ -83,7 +83,7 @@ func TestAuthorizeDecoder_DecodeAuthorize(t *testing.T) {
 				"response_type": {"code"},
 				"client_id":     {"dummy-client"},
 			},
-			expectedError: `response_type "code" is not allowed`,
+			expectedError: `unsupported_response_type: response_type "code" is not allowed`,
 		},
 		{
 			decoderDesc: `allow both "code" and "token" response_type`,
@@ -106,7 +106,7 @@ func TestAuthorizeDecoder_DecodeAuthorize(t *testing.T) {
 				"response_type": {"token"},
 				"client_id":     {"dummy-client"},
 			},
-			expectedError: `response_type "token" is not allowed`,
+			expectedError: `unsupported_response_type: response_type "token" is not allowed`,
 		},
 	}
 
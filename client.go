@@ -0,0 +1,218 @@
+package oasis
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ClientType represents the client types
+// as described in RFC6749 section 2.1.
+type ClientType int
+
+const (
+	// ClientTypeConfidential represents clients capable of
+	// maintaining the confidentiality of their credentials
+	// (e.g. web application on a secure server).
+	ClientTypeConfidential ClientType = iota
+
+	// ClientTypePublic represents clients incapable of
+	// maintaining the confidentiality of their credentials
+	// (e.g. native application and browser-based application).
+	ClientTypePublic
+)
+
+// String implements fmt.Stringer
+func (clientType ClientType) String() string {
+	switch clientType {
+	case ClientTypeConfidential:
+		return "confidential"
+	case ClientTypePublic:
+		return "public"
+	}
+	return fmt.Sprintf("ClientType(%d)", int(clientType))
+}
+
+// Client represents the registration information
+// of a client (RFC6749 section 2).
+type Client struct {
+
+	// ID is the client identifier (RFC6749 section 2.2).
+	ID string `json:"client_id"`
+
+	// Secret is the client password of a confidential client
+	// (RFC6749 section 2.3.1).
+	Secret string `json:"-"`
+
+	// Type of the client.
+	Type ClientType `json:"client_type"`
+
+	// RedirectURIs are the registered redirection endpoints
+	// (RFC6749 section 3.1.2.2). Redirect URI in requests must
+	// match one of them exactly.
+	RedirectURIs []string `json:"redirect_uris"`
+
+	// ResponseTypes are the response_type the client may use.
+	// No restriction if empty.
+	ResponseTypes []string `json:"response_types,omitempty"`
+
+	// GrantTypes are the grant_type the client may use.
+	// No restriction if empty.
+	GrantTypes []string `json:"grant_types,omitempty"`
+
+	// Scopes are the scope the client may request.
+	// No restriction if empty.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// PKCE is the PKCE policy of the client.
+	PKCE PKCEPolicy `json:"-"`
+}
+
+// HasRedirectURI reports whether the uri exactly
+// matches one of the registered RedirectURIs.
+func (client *Client) HasRedirectURI(uri string) bool {
+	return containsString(client.RedirectURIs, uri)
+}
+
+// AllowsResponseType reports whether the client
+// may use the response_type.
+func (client *Client) AllowsResponseType(responseType string) bool {
+	return len(client.ResponseTypes) == 0 || containsString(client.ResponseTypes, responseType)
+}
+
+// AllowsGrantType reports whether the client
+// may use the grant_type.
+func (client *Client) AllowsGrantType(grantType string) bool {
+	return len(client.GrantTypes) == 0 || containsString(client.GrantTypes, grantType)
+}
+
+// VerifySecret reports whether secret is the client secret.
+// Always false for a client without secret.
+func (client *Client) VerifySecret(secret string) bool {
+	if client.Secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) == 1
+}
+
+// ClientStore is the interface to retrieve
+// registered clients.
+type ClientStore interface {
+
+	// GetClient retrieves a client by its client identifier.
+	// Returns ErrClientNotFound if there is no such client.
+	GetClient(ctx context.Context, clientID string) (*Client, error)
+}
+
+// ErrClientNotFound is returned by ClientStore when
+// the requested client does not exist.
+var ErrClientNotFound = errors.New("client not found")
+
+// MemoryClientStore is an in-memory ClientStore implementation.
+// It is safe for concurrent use.
+type MemoryClientStore struct {
+	mutex   sync.RWMutex
+	clients map[string]*Client
+}
+
+// NewMemoryClientStore returns an initialized *MemoryClientStore
+// with the given clients registered.
+func NewMemoryClientStore(clients ...*Client) *MemoryClientStore {
+	store := &MemoryClientStore{
+		clients: make(map[string]*Client),
+	}
+	for _, client := range clients {
+		store.clients[client.ID] = client
+	}
+	return store
+}
+
+// Add registers a client. A client of the same
+// ID will be overwritten.
+func (store *MemoryClientStore) Add(client *Client) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.clients[client.ID] = client
+}
+
+// GetClient implements ClientStore
+func (store *MemoryClientStore) GetClient(ctx context.Context, clientID string) (*Client, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if client, ok := store.clients[clientID]; ok {
+		return client, nil
+	}
+	return nil, ErrClientNotFound
+}
+
+// validateClient checks the client_id and redirect_uri of the
+// AuthorizeRequest against the client registration, as described
+// in RFC6749 section 3.1.2. If redirect_uri is not provided, the
+// only registered redirect URI is filled in.
+func validateClient(ctx context.Context, store ClientStore, ar *AuthorizeRequest) (client *Client, err *Error) {
+	client, getErr := store.GetClient(ctx, ar.ClientID)
+	if getErr == ErrClientNotFound {
+		err = NewError(ErrInvalidRequest, fmt.Sprintf(`client_id "%s" is not registered`, ar.ClientID))
+		return
+	} else if getErr != nil {
+		err = NewError(ErrServerError, "unable to retrieve client")
+		return
+	}
+
+	if ar.RedirectURI == "" {
+		if len(client.RedirectURIs) != 1 {
+			err = NewError(ErrInvalidRequest, "redirect_uri is required but not set")
+			return
+		}
+		ar.RedirectURI = client.RedirectURIs[0]
+		ar.RedirectURIDefaulted = true
+	} else if !client.HasRedirectURI(ar.RedirectURI) {
+		err = NewError(ErrInvalidRequest, "redirect_uri is not registered for the client")
+		return
+	}
+	return
+}
+
+// authenticateClient authenticates the client of a TokenRequest
+// as described in RFC6749 section 3.2.1. Public clients are
+// identified by client_id only.
+//
+// If ClientStore is not set in Context, the client_id is
+// trusted as is and a nil client is returned.
+func authenticateClient(ctx context.Context, actx *Context, tr *TokenRequest) (client *Client, oerr *Error) {
+	if tr.ClientID == "" {
+		oerr = NewError(ErrInvalidRequest, "client_id is required but not set")
+		return
+	}
+	if actx.ClientStore == nil {
+		return
+	}
+
+	client, err := actx.GetClient(ctx, tr.ClientID)
+	if err == ErrClientNotFound {
+		oerr = NewError(ErrInvalidClient, "client authentication failed")
+		return
+	} else if err != nil {
+		oerr = NewError(ErrServerError, "unable to retrieve client")
+		return
+	}
+	if client.Type == ClientTypeConfidential && !client.VerifySecret(tr.ClientSecret) {
+		oerr = NewError(ErrInvalidClient, "client authentication failed")
+		return
+	}
+	if !client.AllowsGrantType(tr.GrantType) {
+		oerr = NewError(ErrUnauthorizedClient, fmt.Sprintf(`grant_type "%s" is not allowed for the client`, tr.GrantType))
+	}
+	return
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
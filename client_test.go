@@ -0,0 +1,271 @@
+package oasis_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+func newTestClientStore() *oasis.MemoryClientStore {
+	return oasis.NewMemoryClientStore(
+		&oasis.Client{
+			ID:           "web-client",
+			Secret:       "web-secret",
+			Type:         oasis.ClientTypeConfidential,
+			RedirectURIs: []string{"https://web.foobar.com/cb"},
+		},
+		&oasis.Client{
+			ID:   "spa-client",
+			Type: oasis.ClientTypePublic,
+			RedirectURIs: []string{
+				"https://spa.foobar.com/cb",
+				"https://spa.foobar.com/silent",
+			},
+			ResponseTypes: []string{"code"},
+			GrantTypes:    []string{oasis.GrantTypeAuthorizationCode},
+			PKCE:          oasis.PKCEPolicy{Required: true, ForbidPlain: true},
+		},
+	)
+}
+
+func TestAuthorizeDecoder_ClientStore(t *testing.T) {
+	actx := &oasis.Context{ClientStore: newTestClientStore()}
+	decoder := oasis.NewAuthorizeDecoder("code", "token")
+
+	tests := []struct {
+		desc             string
+		query            url.Values
+		expectedRedirect string
+		expectedError    string
+	}{
+		{
+			desc: "default redirect_uri",
+			query: url.Values{
+				"response_type": {"code"},
+				"client_id":     {"web-client"},
+			},
+			expectedRedirect: "https://web.foobar.com/cb",
+		},
+		{
+			desc: "registered redirect_uri",
+			query: url.Values{
+				"response_type":         {"code"},
+				"client_id":             {"spa-client"},
+				"redirect_uri":          {"https://spa.foobar.com/silent"},
+				"code_challenge":        {testCodeChallenge},
+				"code_challenge_method": {"S256"},
+			},
+			expectedRedirect: "https://spa.foobar.com/silent",
+		},
+		{
+			desc: "no client_id",
+			query: url.Values{
+				"response_type": {"code"},
+			},
+			expectedError: "invalid_request: client_id is required but not set",
+		},
+		{
+			desc: "unknown client",
+			query: url.Values{
+				"response_type": {"code"},
+				"client_id":     {"evil-client"},
+			},
+			expectedError: `invalid_request: client_id "evil-client" is not registered`,
+		},
+		{
+			desc: "no default redirect_uri",
+			query: url.Values{
+				"response_type": {"code"},
+				"client_id":     {"spa-client"},
+			},
+			expectedError: "invalid_request: redirect_uri is required but not set",
+		},
+		{
+			desc: "redirect_uri not exactly matched",
+			query: url.Values{
+				"response_type": {"code"},
+				"client_id":     {"web-client"},
+				"redirect_uri":  {"https://web.foobar.com/cb/"},
+			},
+			expectedError: "invalid_request: redirect_uri is not registered for the client",
+		},
+		{
+			desc: "response_type not allowed for client",
+			query: url.Values{
+				"response_type": {"token"},
+				"client_id":     {"spa-client"},
+				"redirect_uri":  {"https://spa.foobar.com/cb"},
+			},
+			expectedError: `unauthorized_client: response_type "token" is not allowed for the client`,
+		},
+		{
+			desc: "PKCE required by client",
+			query: url.Values{
+				"response_type": {"code"},
+				"client_id":     {"spa-client"},
+				"redirect_uri":  {"https://spa.foobar.com/cb"},
+			},
+			expectedError: "invalid_request: code_challenge is required but not set",
+		},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/foobar/authorize?"+test.query.Encode(), nil)
+		r = r.WithContext(oasis.WithContext(r.Context(), actx))
+
+		_, ar, err := decoder.DecodeAuthorize(r)
+		if test.expectedError != "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", test.desc)
+			} else if want, have := test.expectedError, err.Error(); want != have {
+				t.Errorf("%s:\nexpected: %#v\ngot:      %#v", test.desc, want, have)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+		}
+		if want, have := test.expectedRedirect, ar.RedirectURI; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := test.query.Get("redirect_uri") == "", ar.RedirectURIDefaulted; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestAuthorizationCodeGrant_ClientStore(t *testing.T) {
+	actx := oasis.Context{
+		TokenStorage: oasis.NewMemoryTokenStorage(),
+		ClientStore:  newTestClientStore(),
+	}
+	tokenEndpoint := oasis.NewTokenEndpoint(
+		actx,
+		oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+		oasis.NewAuthorizationCodeHandler(),
+		oasis.NewResponseEncoder(),
+	)
+
+	tests := []struct {
+		desc          string
+		ar            *oasis.AuthorizeRequest
+		form          url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			desc: "confidential client with defaulted redirect_uri",
+			ar: &oasis.AuthorizeRequest{
+				ClientID:             "web-client",
+				RedirectURI:          "https://web.foobar.com/cb",
+				RedirectURIDefaulted: true,
+			},
+			form: url.Values{
+				"client_id":     {"web-client"},
+				"client_secret": {"web-secret"},
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			desc: "confidential client omitting requested redirect_uri",
+			ar: &oasis.AuthorizeRequest{
+				ClientID:    "web-client",
+				RedirectURI: "https://web.foobar.com/cb",
+			},
+			form: url.Values{
+				"client_id":     {"web-client"},
+				"client_secret": {"web-secret"},
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+		{
+			desc: "confidential client with wrong secret",
+			ar: &oasis.AuthorizeRequest{
+				ClientID:    "web-client",
+				RedirectURI: "https://web.foobar.com/cb",
+			},
+			form: url.Values{
+				"client_id":     {"web-client"},
+				"client_secret": {"wrong-secret"},
+			},
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "invalid_client",
+		},
+		{
+			desc: "public client",
+			ar: &oasis.AuthorizeRequest{
+				ClientID:            "spa-client",
+				RedirectURI:         "https://spa.foobar.com/cb",
+				CodeChallenge:       testCodeChallenge,
+				CodeChallengeMethod: "S256",
+			},
+			form: url.Values{
+				"client_id":     {"spa-client"},
+				"redirect_uri":  {"https://spa.foobar.com/cb"},
+				"code_verifier": {testCodeVerifier},
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			desc: "public client without redirect_uri",
+			ar: &oasis.AuthorizeRequest{
+				ClientID:            "spa-client",
+				RedirectURI:         "https://spa.foobar.com/cb",
+				CodeChallenge:       testCodeChallenge,
+				CodeChallengeMethod: "S256",
+			},
+			form: url.Values{
+				"client_id":     {"spa-client"},
+				"code_verifier": {testCodeVerifier},
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+		{
+			desc: "unknown client",
+			ar: &oasis.AuthorizeRequest{
+				ClientID:    "web-client",
+				RedirectURI: "https://web.foobar.com/cb",
+			},
+			form: url.Values{
+				"client_id": {"evil-client"},
+			},
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: "invalid_client",
+		},
+	}
+
+	for _, test := range tests {
+		test.ar.ResponseType = "code"
+		test.ar.UserID = "dummy-user"
+		rr, err := oasis.NewAuthorizationCodeResponse(oasis.WithContext(context.Background(), &actx), test.ar)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.desc, err)
+		}
+
+		test.form.Set("grant_type", "authorization_code")
+		test.form.Set("code", rr.Query.Get("code"))
+		r, _ := http.NewRequest("POST", "https://foobar.com/token", strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		tokenEndpoint.ServeHTTP(w, r)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if want, have := test.expectedCode, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v: %#v", test.desc, want, have, body)
+		}
+		if test.expectedError != "" {
+			if want, have := test.expectedError, body["error"]; want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+			}
+		}
+	}
+}
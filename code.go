@@ -0,0 +1,180 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// NewAuthorizationCodeResponse issues an Authorization Code for
+// a successfully authorized request, and returns the Authorization
+// Response as described in RFC6749 section 4.1.2.
+//
+// The code is produced by the TokenFactory and saved to the
+// TokenStorage of the *Context in ctx, together with the
+// client_id, redirect_uri, scope and user of the request.
+//
+// If the scope has been narrowed down from the RequestedScope,
+// the granted scope is included in the response.
+//
+// If max_age is requested, the user must have authenticated within
+// it (see AuthorizeRequest.AuthTimeAllowed), so the ID Token has the
+// auth_time claim. Otherwise, an *Error of login_required is returned.
+func NewAuthorizationCodeResponse(ctx context.Context, ar *AuthorizeRequest) (rr *RedirectResponse, err error) {
+	actx := contextWithDefaults(ctx)
+	if actx == nil || actx.TokenStorage == nil {
+		err = fmt.Errorf("token storage is required but not set in context")
+		return
+	}
+	if ar.ResponseType != "code" {
+		err = fmt.Errorf(`response_type "%s" is not "code"`, ar.ResponseType)
+		return
+	}
+	if ar.UserID == "" {
+		err = fmt.Errorf("authorize request is not authenticated")
+		return
+	}
+	if ar.MaxAge != nil && (ar.AuthTime == nil || !ar.AuthTimeAllowed(*ar.AuthTime)) {
+		err = NewError(ErrLoginRequired, "user is not authenticated within max_age")
+		return
+	}
+
+	code, err := actx.NewToken(ctx, TokenTypeAuthorizationCode, ar, actx.AuthorizationCodeTTL)
+	if err != nil {
+		return
+	}
+	code.RedirectURI = ar.RedirectURI
+	code.RedirectURIDefaulted = ar.RedirectURIDefaulted
+	code.CodeChallenge = ar.CodeChallenge
+	code.CodeChallengeMethod = ar.CodeChallengeMethod
+	code.Nonce = ar.Nonce
+	code.AuthTime = ar.AuthTime
+	if err = actx.SaveToken(ctx, code); err != nil {
+		return
+	}
+
+	query := url.Values{"code": {code.Value}}
+	if ar.RequestedScope != "" && ar.RequestedScope != ar.Scope {
+		query.Set("scope", ar.Scope)
+	}
+	if ar.State != "" {
+		query.Set("state", ar.State)
+	}
+	rr = &RedirectResponse{
+		HeaderCache: make(http.Header),
+		RedirectURI: ar.RedirectURI,
+		Query:       query,
+	}
+	return
+}
+
+// NewAuthorizationCodeHandler returns a TokenHandler to redeem
+// Authorization Code as described in RFC6749 section 4.1.3.
+//
+// The code is removed from TokenStorage once redeemed, so each
+// code can only be used once. The client must be authenticated
+// (if Context.ClientStore is set), and the client_id and
+// redirect_uri must match the ones the code is issued for. The
+// redirect_uri may only be omitted if the authorization request
+// omitted it too. If the code is issued with PKCE, the code_verifier must match the
+// code_challenge (RFC7636 section 4.6).
+//
+// Access token (and refresh token if Context.RefreshTokenTTL
+// is set) is produced by the TokenFactory and saved to the
+// TokenStorage of the *Context in ctx. If the openid scope is
+// granted and Context.IDTokenSigner is set, an ID Token is
+// issued too.
+func NewAuthorizationCodeHandler() TokenHandler {
+	return TokenHandlerFunc(handleAuthorizationCode)
+}
+
+func handleAuthorizationCode(ctx context.Context, tr *TokenRequest, decodeErr error) Responder {
+	if decodeErr != nil {
+		return NewTokenErrorResponse(decodeErr)
+	}
+	actx := contextWithDefaults(ctx)
+	if actx == nil || actx.TokenStorage == nil {
+		return NewTokenErrorResponse(NewError(ErrServerError, "token storage is not set"))
+	}
+	_, oerr := authenticateClient(ctx, actx, tr)
+	if oerr != nil {
+		return NewTokenErrorResponse(oerr)
+	}
+	if tr.Code == "" {
+		return NewTokenErrorResponse(NewError(ErrInvalidRequest, "code is required but not set"))
+	}
+
+	code, err := actx.GetToken(ctx, TokenTypeAuthorizationCode, tr.Code)
+	if err == ErrTokenNotFound {
+		return NewTokenErrorResponse(NewError(ErrInvalidGrant, "code is invalid or expired"))
+	} else if err != nil {
+		return NewTokenErrorResponse(NewError(ErrServerError, "unable to retrieve code"))
+	}
+
+	// remove the code before any further check so a
+	// code can never be redeemed twice, even by
+	// concurrent requests.
+	if err = actx.DeleteToken(ctx, TokenTypeAuthorizationCode, tr.Code); err == ErrTokenNotFound {
+		return NewTokenErrorResponse(NewError(ErrInvalidGrant, "code is invalid or expired"))
+	} else if err != nil {
+		return NewTokenErrorResponse(NewError(ErrServerError, "unable to redeem code"))
+	}
+
+	if code.ClientID != tr.ClientID {
+		return NewTokenErrorResponse(NewError(ErrInvalidGrant, "code was issued to another client"))
+	}
+	// RFC6749 section 4.1.3: redirect_uri is required if it is
+	// included in the authorization request.
+	if code.RedirectURI != tr.RedirectURI && !(tr.RedirectURI == "" && code.RedirectURIDefaulted) {
+		return NewTokenErrorResponse(NewError(ErrInvalidGrant, "redirect_uri does not match the authorization request"))
+	}
+	if code.CodeChallenge == "" && tr.CodeVerifier != "" {
+		return NewTokenErrorResponse(NewError(ErrInvalidGrant, "code_verifier is set but the authorization request has no code_challenge"))
+	}
+	if code.CodeChallenge != "" && tr.CodeVerifier == "" {
+		return NewTokenErrorResponse(NewError(ErrInvalidRequest, "code_verifier is required but not set"))
+	}
+	if code.CodeChallenge != "" && !verifyCodeVerifier(tr.CodeVerifier, code.CodeChallenge, code.CodeChallengeMethod) {
+		return NewTokenErrorResponse(NewError(ErrInvalidGrant, "code_verifier does not match code_challenge"))
+	}
+
+	ar := &AuthorizeRequest{
+		ClientID:    code.ClientID,
+		RedirectURI: code.RedirectURI,
+		Scope:       code.Scope,
+		UserID:      code.UserID,
+		Nonce:       code.Nonce,
+		AuthTime:    code.AuthTime,
+	}
+	accessToken, refreshToken, err := issueTokens(ctx, actx, ar)
+	if err != nil {
+		return NewTokenErrorResponse(NewError(ErrServerError, "unable to issue token"))
+	}
+	rsp := NewTokenResponse(accessToken, refreshToken)
+	if ar.IsOpenID() && actx.IDTokenSigner != nil {
+		if rsp.IDToken, err = NewIDToken(ctx, ar, accessToken.Value); err != nil {
+			return NewTokenErrorResponse(NewError(ErrServerError, "unable to issue id token"))
+		}
+	}
+	return rsp
+}
+
+// issueTokens produces and saves an access token, and a
+// refresh token if Context.RefreshTokenTTL is set.
+func issueTokens(ctx context.Context, actx *Context, ar *AuthorizeRequest) (accessToken, refreshToken *Token, err error) {
+	if accessToken, err = actx.NewToken(ctx, TokenTypeAccessToken, ar, actx.AccessTokenTTL); err != nil {
+		return
+	}
+	if err = actx.SaveToken(ctx, accessToken); err != nil {
+		return
+	}
+	if actx.RefreshTokenTTL <= 0 {
+		return
+	}
+	if refreshToken, err = actx.NewToken(ctx, TokenTypeRefreshToken, ar, actx.RefreshTokenTTL); err != nil {
+		return
+	}
+	err = actx.SaveToken(ctx, refreshToken)
+	return
+}
@@ -0,0 +1,205 @@
+package oasis_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	actx := oasis.Context{
+		TokenStorage: oasis.NewMemoryTokenStorage(),
+	}
+
+	authorizeEndpoint := oasis.NewAuthorizeEndpoint(
+		actx,
+		oasis.NewAuthorizeDecoder("code"),
+		oasis.AuthorizeHandlerFunc(func(
+			ctx context.Context,
+			ar *oasis.AuthorizeRequest,
+			decodeErr error,
+		) oasis.Responder {
+			ar.UserID = "dummy-user"
+			rr, err := oasis.NewAuthorizationCodeResponse(ctx, ar)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			return rr
+		}),
+		oasis.NewResponseEncoder(),
+	)
+	tokenEndpoint := oasis.NewTokenEndpoint(
+		actx,
+		oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+		oasis.NewAuthorizationCodeHandler(),
+		oasis.NewResponseEncoder(),
+	)
+
+	// authorization request
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dummy-client"},
+		"redirect_uri":  {"https://client.foobar.com/cb"},
+		"scope":         {"read"},
+		"state":         {"dummy-state"},
+	}
+	r, _ := http.NewRequest("GET", "https://foobar.com/authorize?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	authorizeEndpoint.ServeHTTP(w, r)
+
+	if want, have := http.StatusTemporaryRedirect, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if want, have := "client.foobar.com", location.Host; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "dummy-state", location.Query().Get("state"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("expected code, got nothing")
+	}
+
+	redeem := func(form url.Values) (int, map[string]interface{}) {
+		r, _ := http.NewRequest("POST", "https://foobar.com/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		tokenEndpoint.ServeHTTP(w, r)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	tests := []struct {
+		desc          string
+		form          url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			desc: "mismatched redirect_uri",
+			form: url.Values{
+				"grant_type":   {"authorization_code"},
+				"code":         {code},
+				"client_id":    {"dummy-client"},
+				"redirect_uri": {"https://evil.foobar.com/cb"},
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+		{
+			desc: "code burnt by the failed attempt",
+			form: url.Values{
+				"grant_type":   {"authorization_code"},
+				"code":         {code},
+				"client_id":    {"dummy-client"},
+				"redirect_uri": {"https://client.foobar.com/cb"},
+			},
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "invalid_grant",
+		},
+	}
+
+	for _, test := range tests {
+		status, body := redeem(test.form)
+		if want, have := test.expectedCode, status; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := test.expectedError, body["error"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestAuthorizationCodeGrant_redeem(t *testing.T) {
+	actx := oasis.Context{
+		TokenStorage: oasis.NewMemoryTokenStorage(),
+		TokenFactory: oasis.NewTokenFactory(0, nil),
+	}
+	tokenEndpoint := oasis.NewTokenEndpoint(
+		actx,
+		oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+		oasis.NewAuthorizationCodeHandler(),
+		oasis.NewResponseEncoder(),
+	)
+
+	newCode := func() string {
+		rr, err := oasis.NewAuthorizationCodeResponse(oasis.WithContext(context.Background(), &actx), &oasis.AuthorizeRequest{
+			ResponseType: "code",
+			ClientID:     "dummy-client",
+			RedirectURI:  "https://client.foobar.com/cb",
+			Scope:        "read",
+			UserID:       "dummy-user",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rr.Query.Get("code")
+	}
+
+	redeem := func(form url.Values) (int, map[string]interface{}) {
+		r, _ := http.NewRequest("POST", "https://foobar.com/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		tokenEndpoint.ServeHTTP(w, r)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	// wrong client
+	status, body := redeem(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {newCode()},
+		"client_id":    {"other-client"},
+		"redirect_uri": {"https://client.foobar.com/cb"},
+	})
+	if want, have := http.StatusBadRequest, status; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "invalid_grant", body["error"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// success, then reuse
+	code := newCode()
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"client_id":    {"dummy-client"},
+		"redirect_uri": {"https://client.foobar.com/cb"},
+	}
+	status, body = redeem(form)
+	if want, have := http.StatusOK, status; want != have {
+		t.Fatalf("expected %#v, got %#v: %#v", want, have, body)
+	}
+	if want, have := "Bearer", body["token_type"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "read", body["scope"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	accessToken, _ := body["access_token"].(string)
+	token, err := actx.GetToken(context.Background(), oasis.TokenTypeAccessToken, accessToken)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if want, have := "dummy-user", token.UserID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	status, body = redeem(form)
+	if want, have := http.StatusBadRequest, status; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "invalid_grant", body["error"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,446 @@
+package oasis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the consent form fields.
+const (
+	// ConsentScopeParam is the name of the checkbox fields of
+	// the approved scopes.
+	ConsentScopeParam = "scope"
+
+	// ConsentActionParam is the name of the submit button
+	// field. Its value is either "approve" or "deny".
+	ConsentActionParam = "consent"
+)
+
+// ErrConsentNotFound is returned by ConsentStore when the
+// user has not consented to the client.
+var ErrConsentNotFound = errors.New("consent not found")
+
+// Consent is the decision of a user to grant scopes to a client.
+type Consent struct {
+	UserID    string
+	ClientID  string
+	Scopes    Scope
+	GrantedAt time.Time
+}
+
+// Covers reports whether all the scope is granted.
+func (consent *Consent) Covers(scope Scope) bool {
+	return scope.IsSubsetOf(consent.Scopes)
+}
+
+// ConsentStore stores the consents of users.
+//
+// Implementations must be safe for concurrent use.
+type ConsentStore interface {
+
+	// GetConsent retrieves the consent of the user to the client.
+	// Returns ErrConsentNotFound if there is none.
+	GetConsent(ctx context.Context, userID, clientID string) (*Consent, error)
+
+	// SaveConsent creates or replaces the consent of the
+	// user to the client.
+	SaveConsent(ctx context.Context, consent *Consent) error
+
+	// DeleteConsent removes the consent of the user to the client.
+	// Returns ErrConsentNotFound if there is none.
+	DeleteConsent(ctx context.Context, userID, clientID string) error
+
+	// GetConsentsByUser retrieves all consents of the user.
+	GetConsentsByUser(ctx context.Context, userID string) ([]*Consent, error)
+}
+
+// WithdrawConsent removes the consent of the user to the client
+// from the store. If the TokenStorage of the *Context in ctx is set,
+// all the tokens the user has authorized the client are revoked too,
+// so the client has to ask for consent again.
+func WithdrawConsent(ctx context.Context, store ConsentStore, userID, clientID string) error {
+	if err := store.DeleteConsent(ctx, userID, clientID); err != nil {
+		return err
+	}
+
+	actx := GetContext(ctx)
+	if actx == nil || actx.TokenStorage == nil {
+		return nil
+	}
+	tokens, err := actx.GetTokensByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.ClientID != clientID {
+			continue
+		}
+		if err = actx.DeleteToken(ctx, token.Type, token.Value); err != nil && err != ErrTokenNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConsentScope is a scope shown on the consent page.
+type ConsentScope struct {
+
+	// Name is the scope token.
+	Name string
+
+	// Description is the human-readable description of the scope.
+	Description string
+}
+
+// ConsentPageData is the data to render the consent page template.
+type ConsentPageData struct {
+
+	// Action is the URL the form is posted to.
+	Action string
+
+	// Hidden are the hidden form fields (name to value) that
+	// must be posted back with the form.
+	Hidden map[string]string
+
+	// Error is the user understandable message about the
+	// previous attempt, if any.
+	Error string
+
+	// ClientID is the id of the client requesting authorization.
+	ClientID string
+
+	// Scopes are the requested scopes.
+	Scopes []ConsentScope
+
+	// Branding of the consent page.
+	Branding Branding
+}
+
+// DefaultConsentTemplate is the template used to render the
+// consent page by ConsentHandler if no template is set.
+var DefaultConsentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{ .ClientID }}{{ with .Branding.Name }} - {{ . }}{{ end }}</title>
+{{- with .Branding.StylesheetURL }}
+<link rel="stylesheet" href="{{ . }}">
+{{- end }}
+</head>
+<body>
+{{- with .Branding.LogoURL }}
+<img src="{{ . }}" alt="{{ $.Branding.Name }}">
+{{- end }}
+<h1>Authorize {{ .ClientID }}</h1>
+{{- with .Error }}
+<p role="alert"><strong>{{ . }}</strong></p>
+{{- end }}
+<form method="post" action="{{ .Action }}">
+{{- range $name, $value := .Hidden }}
+<input type="hidden" name="{{ $name }}" value="{{ $value }}">
+{{- end }}
+{{- if .Scopes }}
+<p>{{ .ClientID }} would like to:</p>
+<ul>
+{{- range .Scopes }}
+<li><label><input type="checkbox" name="scope" value="{{ .Name }}" checked> {{ .Description }}</label></li>
+{{- end }}
+</ul>
+{{- else }}
+<p>{{ .ClientID }} would like to access your account.</p>
+{{- end }}
+<p><button type="submit" name="consent" value="approve">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button></p>
+</form>
+{{- with .Branding.SupportURL }}
+<p><a href="{{ . }}">Get help</a></p>
+{{- end }}
+</body>
+</html>`))
+
+// ConsentHandler is an AuthorizeHandler of the consent stage
+// (usually StageToAuthorize).
+//
+// The AuthorizeRequest must have an authenticated user (see
+// RequireUser). If the user has consented to the client for all the
+// requested scopes in Store, the Authorization Response is returned
+// right away, unless the request has prompt=consent. If not and the
+// request has prompt=none, it is refused with consent_required.
+// Otherwise, the requested scopes are listed for the user
+// to approve some or all of them, or to deny the request.
+//
+// Approved scopes are added to the consent in Store, and the scope
+// of the request is narrowed down to them. Then the user-agent is
+// redirected back to the authorization endpoint to get the response.
+// As LoginHandler, the AuthorizeRequest is carried by the
+// PendingAuthorizationStore or StateSealer of the Context, and the
+// form is protected from CSRF.
+//
+// The Authorization Response is only returned once for a pending
+// authorization, as it is removed from the PendingAuthorizationStore.
+type ConsentHandler struct {
+
+	// Store stores the consents of users.
+	Store ConsentStore
+
+	// Descriptions are the human-readable descriptions of scopes.
+	// The scope itself is shown if it has no description.
+	Descriptions map[string]string
+
+	// Template renders the consent page with *ConsentPageData.
+	// DefaultConsentTemplate is used if not set.
+	Template *template.Template
+
+	// Branding is passed to Template with every consent page.
+	Branding Branding
+}
+
+// NewConsentHandler returns a *ConsentHandler that remembers consents
+// in store, and describes scopes with descriptions.
+func NewConsentHandler(store ConsentStore, descriptions map[string]string) *ConsentHandler {
+	return &ConsentHandler{
+		Store:        store,
+		Descriptions: descriptions,
+	}
+}
+
+// HandleAuthorizeRequest implements AuthorizeHandler
+func (ch *ConsentHandler) HandleAuthorizeRequest(ctx context.Context, ar *AuthorizeRequest, decodeErr error) Responder {
+	if decodeErr != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, decodeErr)
+	}
+	actx, err := formStageContext(ctx, ar)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	if ar.UserID == "" {
+		return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrAccessDenied, "user is not authenticated"))
+	}
+	r := ar.HTTPRequest
+
+	requested, err := ParseScope(ar.Scope)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidScope, err.Error()))
+	}
+	consent, err := ch.Store.GetConsent(ctx, ar.UserID, ar.ClientID)
+	if err == ErrConsentNotFound {
+		consent = nil
+	} else if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+
+	if r.Method != http.MethodPost {
+		covered := consent != nil && consent.Covers(requested)
+		switch {
+		case covered && !ar.HasPrompt(PromptConsent):
+			return authorizeResponse(ctx, actx, ar)
+		case ar.HasPrompt(PromptNone):
+			return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrConsentRequired, "user has not consented to the requested scope"))
+		}
+		return ch.consentPage(ctx, actx, ar, requested, "")
+	}
+	if !validCSRFToken(ar) {
+		return ch.consentPage(ctx, actx, ar, requested, "Your session has expired. Please try again.")
+	}
+
+	if r.PostFormValue(ConsentActionParam) != "approve" {
+		if actx.PendingAuthorizationStore != nil && ar.PendingHandle != "" {
+			switch err := actx.DeletePending(ctx, ar.PendingHandle); err {
+			case nil:
+			case ErrPendingNotFound:
+				return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has expired"))
+			default:
+				return NewAuthorizeErrorResponse(ctx, ar, err)
+			}
+		}
+		rspr := NewAuthorizeErrorResponse(ctx, ar, NewError(ErrAccessDenied, "the resource owner denied the request"))
+		if rr, ok := rspr.(*RedirectResponse); ok {
+			rr.Code = http.StatusSeeOther
+			clearCarrier(r, rr.HeaderCache)
+		}
+		return rspr
+	}
+
+	// only the requested scopes may be approved
+	r.ParseForm()
+	approved := requested.Intersect(r.PostForm[ConsentScopeParam])
+
+	if consent == nil {
+		consent = &Consent{UserID: ar.UserID, ClientID: ar.ClientID}
+	}
+	for _, token := range approved {
+		if !consent.Scopes.Contains(token) {
+			consent.Scopes = append(consent.Scopes, token)
+		}
+	}
+	consent.GrantedAt = time.Now()
+	if err = ch.Store.SaveConsent(ctx, consent); err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+
+	if ar.RequestedScope == "" {
+		ar.RequestedScope = ar.Scope
+	}
+	ar.Scope = approved.String()
+
+	// prompt=consent is satisfied now, or the consent page
+	// would be shown again when redirected back
+	var prompt []string
+	for _, value := range strings.Fields(ar.Prompt) {
+		if value != PromptConsent {
+			prompt = append(prompt, value)
+		}
+	}
+	ar.Prompt = strings.Join(prompt, " ")
+	return proceedToStage(ctx, actx, ar, ar.Stage)
+}
+
+// consentPage renders the consent form with the AuthorizeRequest
+// carried in the cookie.
+func (ch *ConsentHandler) consentPage(ctx context.Context, actx *Context, ar *AuthorizeRequest, requested Scope, message string) Responder {
+	carrier, err := carryRequest(ctx, actx, ar)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	hidden := make(map[string]string)
+
+	data := &ConsentPageData{
+		Action:   ar.HTTPRequest.URL.Path,
+		Hidden:   hidden,
+		Error:    message,
+		ClientID: ar.ClientID,
+		Branding: ch.Branding,
+	}
+	for _, token := range requested {
+		description, ok := ch.Descriptions[token]
+		if !ok {
+			description = token
+		}
+		data.Scopes = append(data.Scopes, ConsentScope{Name: token, Description: description})
+	}
+
+	tmpl := ch.Template
+	if tmpl == nil {
+		tmpl = DefaultConsentTemplate
+	}
+	rsp, err := renderFormPage(ar, tmpl, data, hidden, carrier)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	return rsp
+}
+
+// authorizeResponse returns the Authorization Response of the
+// authorized request. The pending authorization, if any, is removed
+// first, or the sealed one recorded in the ReplayCache, so only one
+// response is returned for it. The carrier cookie is cleared.
+func authorizeResponse(ctx context.Context, actx *Context, ar *AuthorizeRequest) Responder {
+	if actx.PendingAuthorizationStore != nil && ar.PendingHandle != "" {
+		switch err := actx.DeletePending(ctx, ar.PendingHandle); err {
+		case nil:
+		case ErrPendingNotFound:
+			return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has expired"))
+		default:
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+	}
+	if ar.SealedStateID != "" {
+		if actx.ReplayCache == nil {
+			return NewAuthorizeErrorResponse(ctx, ar, fmt.Errorf("replay cache is required but not set in context"))
+		}
+		switch err := actx.ReplayCache.UseOnce(ctx, ar.SealedStateID); err {
+		case nil:
+		case ErrSealedStateReplayed:
+			return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has expired"))
+		default:
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+	}
+
+	var rr *RedirectResponse
+	var err error
+	if ar.ResponseType == "token" {
+		rr, err = NewImplicitTokenResponse(ctx, ar)
+	} else {
+		rr, err = NewAuthorizationCodeResponse(ctx, ar)
+	}
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	clearCarrier(ar.HTTPRequest, rr.HeaderCache)
+	return rr
+}
+
+// MemoryConsentStore is an in-memory ConsentStore
+// implementation, mainly intended for testing.
+type MemoryConsentStore struct {
+	mutex    sync.Mutex
+	consents map[string]map[string]*Consent
+}
+
+// NewMemoryConsentStore returns an initialized *MemoryConsentStore.
+func NewMemoryConsentStore() *MemoryConsentStore {
+	return &MemoryConsentStore{
+		consents: make(map[string]map[string]*Consent),
+	}
+}
+
+// GetConsent implements ConsentStore
+func (store *MemoryConsentStore) GetConsent(ctx context.Context, userID, clientID string) (*Consent, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	consent, ok := store.consents[userID][clientID]
+	if !ok {
+		return nil, ErrConsentNotFound
+	}
+	return copyConsent(consent), nil
+}
+
+// SaveConsent implements ConsentStore
+func (store *MemoryConsentStore) SaveConsent(ctx context.Context, consent *Consent) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.consents[consent.UserID] == nil {
+		store.consents[consent.UserID] = make(map[string]*Consent)
+	}
+	store.consents[consent.UserID][consent.ClientID] = copyConsent(consent)
+	return nil
+}
+
+// DeleteConsent implements ConsentStore
+func (store *MemoryConsentStore) DeleteConsent(ctx context.Context, userID, clientID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.consents[userID][clientID]; !ok {
+		return ErrConsentNotFound
+	}
+	delete(store.consents[userID], clientID)
+	return nil
+}
+
+// GetConsentsByUser implements ConsentStore
+func (store *MemoryConsentStore) GetConsentsByUser(ctx context.Context, userID string) ([]*Consent, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	consents := make([]*Consent, 0, len(store.consents[userID]))
+	for _, consent := range store.consents[userID] {
+		consents = append(consents, copyConsent(consent))
+	}
+	sort.Slice(consents, func(i, j int) bool {
+		return consents[i].ClientID < consents[j].ClientID
+	})
+	return consents, nil
+}
+
+func copyConsent(consent *Consent) *Consent {
+	copied := *consent
+	copied.Scopes = append([]string(nil), consent.Scopes...)
+	return &copied
+}
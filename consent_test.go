@@ -0,0 +1,362 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestConsentHandler(t *testing.T) {
+	pending := oasis.NewMemoryPendingAuthorizationStore(time.Minute)
+	consents := oasis.NewMemoryConsentStore()
+	storage := oasis.NewMemoryTokenStorage()
+	actx := oasis.Context{
+		ClientStore:               newTestClientStore(),
+		TokenStorage:              storage,
+		PendingAuthorizationStore: pending,
+	}
+
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageToAuthorize, oasis.NewConsentHandler(consents, map[string]string{
+		"read": "Read your files",
+	}))
+	endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+
+	// start requests the scope at the consent stage
+	start := func(scope string) *httptest.ResponseRecorder {
+		handle, _ := pending.CreatePending(context.Background(), &oasis.AuthorizeRequest{
+			ResponseType: "code",
+			ClientID:     "web-client",
+			RedirectURI:  "https://web.foobar.com/cb",
+			Scope:        scope,
+			State:        "some-state",
+			Stage:        oasis.StageToAuthorize,
+			UserID:       "dummy-user",
+		})
+		r := httptest.NewRequest("GET", "/authorize", nil)
+		r.AddCookie(&http.Cookie{Name: oasis.PendingHandleParam, Value: handle})
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		return w
+	}
+	post := func(page *httptest.ResponseRecorder, form url.Values) *httptest.ResponseRecorder {
+		for key, values := range hiddenFields(page.Body.String()) {
+			form[key] = values
+		}
+		r := httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range page.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		return w
+	}
+	codeScope := func(w *httptest.ResponseRecorder) string {
+		location, _ := url.Parse(w.Header().Get("Location"))
+		code, err := storage.GetToken(context.Background(), oasis.TokenTypeAuthorizationCode, location.Query().Get("code"))
+		if err != nil {
+			t.Fatalf("expected code in %#v: %s", w.Header().Get("Location"), err)
+		}
+		return code.Scope
+	}
+
+	// consent page
+	page := start("read write")
+	if want, have := http.StatusOK, page.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	for _, expected := range []string{`value="read" checked> Read your files`, `value="write" checked> write`} {
+		if !strings.Contains(page.Body.String(), expected) {
+			t.Errorf("expected %#v in page, got %s", expected, page.Body.String())
+		}
+	}
+
+	// approve one of the scopes
+	w := post(page, url.Values{
+		oasis.ConsentActionParam: {"approve"},
+		oasis.ConsentScopeParam:  {"read", "admin"},
+	})
+	if want, have := http.StatusSeeOther, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	r := httptest.NewRequest("GET", w.Header().Get("Location"), nil)
+	r.AddCookie(responseCookie(w, oasis.PendingHandleParam))
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if want, have := "read", codeScope(w); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if cookie := responseCookie(w, oasis.PendingHandleParam); cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("expected the carrier cookie to be cleared, got %#v", w.Result().Cookies())
+	}
+
+	// the response is only returned once
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if strings.Contains(w.Header().Get("Location"), "code=") {
+		t.Errorf("unexpected second code %#v", w.Header().Get("Location"))
+	}
+
+	// remembered for the subset
+	if w = start("read"); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected redirection, got %#v", w.Code)
+	}
+	if want, have := "read", codeScope(w); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// asked again for more
+	page = start("read write")
+	if want, have := http.StatusOK, page.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+
+	// deny
+	w = post(page, url.Values{oasis.ConsentActionParam: {"deny"}})
+	if want, have := http.StatusSeeOther, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "https://web.foobar.com/cb?error=access_denied&error_description=the+resource+owner+denied+the+request&state=some-state", w.Header().Get("Location"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if cookie := responseCookie(w, oasis.PendingHandleParam); cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("expected the carrier cookie to be cleared, got %#v", w.Result().Cookies())
+	}
+
+	// withdraw
+	ctx := oasis.WithContext(context.Background(), &actx)
+	storage.SaveToken(ctx, &oasis.Token{Type: oasis.TokenTypeAccessToken, Value: "access", ClientID: "web-client", UserID: "dummy-user"})
+	storage.SaveToken(ctx, &oasis.Token{Type: oasis.TokenTypeAccessToken, Value: "other", ClientID: "spa-client", UserID: "dummy-user"})
+	if err := oasis.WithdrawConsent(ctx, consents, "dummy-user", "web-client"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := consents.GetConsent(ctx, "dummy-user", "web-client"); err != oasis.ErrConsentNotFound {
+		t.Errorf("expected ErrConsentNotFound, got %#v", err)
+	}
+	if _, err := storage.GetToken(ctx, oasis.TokenTypeAccessToken, "access"); err != oasis.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound, got %#v", err)
+	}
+	if _, err := storage.GetToken(ctx, oasis.TokenTypeAccessToken, "other"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if want, have := http.StatusOK, start("read").Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestConsentHandler_promptConsent(t *testing.T) {
+	pending := oasis.NewMemoryPendingAuthorizationStore(time.Minute)
+	consents := oasis.NewMemoryConsentStore()
+	consents.SaveConsent(context.Background(), &oasis.Consent{UserID: "dummy-user", ClientID: "web-client", Scopes: oasis.Scope{"openid"}})
+	actx := oasis.Context{
+		ClientStore:               newTestClientStore(),
+		TokenStorage:              oasis.NewMemoryTokenStorage(),
+		PendingAuthorizationStore: pending,
+	}
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageToAuthorize, oasis.NewConsentHandler(consents, nil))
+	endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+
+	// asked again, though consented already
+	handle, _ := pending.CreatePending(context.Background(), &oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Scope:        "openid",
+		Prompt:       "login consent",
+		Stage:        oasis.StageToAuthorize,
+		UserID:       "dummy-user",
+	})
+	r := httptest.NewRequest("GET", "/authorize", nil)
+	r.AddCookie(&http.Cookie{Name: oasis.PendingHandleParam, Value: handle})
+	page := httptest.NewRecorder()
+	endpoint.ServeHTTP(page, r)
+	if want, have := http.StatusOK, page.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+
+	// approved, then the response is returned
+	form := hiddenFields(page.Body.String())
+	form.Set(oasis.ConsentActionParam, "approve")
+	form.Set(oasis.ConsentScopeParam, "openid")
+	r = httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range page.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if want, have := http.StatusSeeOther, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	r = httptest.NewRequest("GET", w.Header().Get("Location"), nil)
+	r.AddCookie(responseCookie(w, oasis.PendingHandleParam))
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); !strings.Contains(location, "code=") {
+		t.Errorf("expected code, got %#v (%d)", location, w.Code)
+	}
+}
+
+func TestConsentHandler_sealed(t *testing.T) {
+	sealer, _ := oasis.NewAESStateSealer(time.Minute, bytes.Repeat([]byte("k"), 32))
+	consents := oasis.NewMemoryConsentStore()
+	consents.SaveConsent(context.Background(), &oasis.Consent{UserID: "dummy-user", ClientID: "web-client", Scopes: oasis.Scope{"read"}})
+	actx := oasis.Context{
+		ClientStore:  newTestClientStore(),
+		TokenStorage: oasis.NewMemoryTokenStorage(),
+		StateSealer:  sealer,
+	}
+	sealed, _ := sealer.Seal(&oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Scope:        "read",
+		Stage:        oasis.StageToAuthorize,
+		UserID:       "dummy-user",
+	})
+	authorize := func(actx oasis.Context) string {
+		mux := oasis.NewAuthorizeHandlerMux()
+		mux.Add(oasis.StageToAuthorize, oasis.NewConsentHandler(consents, nil))
+		endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+		r := httptest.NewRequest("GET", "/authorize", nil)
+		r.AddCookie(&http.Cookie{Name: oasis.SealedStateParam, Value: sealed})
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		return w.Header().Get("Location")
+	}
+
+	// the replay cache is required
+	if location := authorize(actx); !strings.Contains(location, "error=server_error") {
+		t.Errorf("expected server_error redirection, got %#v", location)
+	}
+
+	// the sealed state is used only once
+	actx.ReplayCache = oasis.NewMemoryReplayCache(time.Minute)
+	if location := authorize(actx); !strings.Contains(location, "code=") {
+		t.Errorf("expected code, got %#v", location)
+	}
+	if location := authorize(actx); !strings.Contains(location, "error=invalid_request") {
+		t.Errorf("expected invalid_request redirection, got %#v", location)
+	}
+}
+
+func TestConsentHandler_sealedReplay(t *testing.T) {
+	sealer, _ := oasis.NewAESStateSealer(time.Minute, bytes.Repeat([]byte("k"), 32))
+	actx := oasis.Context{
+		ClientStore:  newTestClientStore(),
+		TokenStorage: oasis.NewMemoryTokenStorage(),
+		StateSealer:  sealer,
+		ReplayCache:  oasis.NewMemoryReplayCache(time.Minute),
+	}
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageToAuthorize, oasis.NewConsentHandler(oasis.NewMemoryConsentStore(), nil))
+	endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+	authorize := func(carrier *http.Cookie) string {
+		r := httptest.NewRequest("GET", "/authorize", nil)
+		r.AddCookie(carrier)
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		return w.Header().Get("Location")
+	}
+
+	// the consent page reseals the request
+	sealed, _ := sealer.Seal(&oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Scope:        "read",
+		Stage:        oasis.StageToAuthorize,
+		UserID:       "dummy-user",
+	})
+	first := &http.Cookie{Name: oasis.SealedStateParam, Value: sealed}
+	r := httptest.NewRequest("GET", "/authorize", nil)
+	r.AddCookie(first)
+	page := httptest.NewRecorder()
+	endpoint.ServeHTTP(page, r)
+	second := responseCookie(page, oasis.SealedStateParam)
+	if second == nil || second.Value == sealed {
+		t.Fatalf("expected a resealed carrier cookie, got %#v", page.Result().Cookies())
+	}
+
+	// approved
+	form := hiddenFields(page.Body.String())
+	form.Set(oasis.ConsentActionParam, "approve")
+	form.Set(oasis.ConsentScopeParam, "read")
+	r = httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range page.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	third := responseCookie(w, oasis.SealedStateParam)
+	if third == nil {
+		t.Fatalf("expected a carrier cookie, got %#v", w.Result().Cookies())
+	}
+	if location := authorize(third); !strings.Contains(location, "code=") {
+		t.Errorf("expected code, got %#v", location)
+	}
+
+	// none of the cookies of the earlier stages gets another code
+	for i, carrier := range []*http.Cookie{first, second, third} {
+		if location := authorize(carrier); !strings.Contains(location, "error=invalid_request") {
+			t.Errorf("cookie %d: expected invalid_request redirection, got %#v", i, location)
+		}
+	}
+}
+
+// brokenPendingStore fails to delete pending authorizations.
+type brokenPendingStore struct {
+	*oasis.MemoryPendingAuthorizationStore
+}
+
+func (brokenPendingStore) DeletePending(ctx context.Context, handle string) error {
+	return errors.New("database is down")
+}
+
+func TestConsentHandler_denyError(t *testing.T) {
+	pending := brokenPendingStore{oasis.NewMemoryPendingAuthorizationStore(time.Minute)}
+	actx := oasis.Context{
+		ClientStore:               newTestClientStore(),
+		PendingAuthorizationStore: pending,
+	}
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageToAuthorize, oasis.NewConsentHandler(oasis.NewMemoryConsentStore(), nil))
+	endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+
+	handle, _ := pending.CreatePending(context.Background(), &oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Scope:        "read",
+		Stage:        oasis.StageToAuthorize,
+		UserID:       "dummy-user",
+	})
+	carrier := &http.Cookie{Name: oasis.PendingHandleParam, Value: handle}
+	r := httptest.NewRequest("GET", "/authorize", nil)
+	r.AddCookie(carrier)
+	page := httptest.NewRecorder()
+	endpoint.ServeHTTP(page, r)
+
+	form := hiddenFields(page.Body.String())
+	form.Set(oasis.ConsentActionParam, "deny")
+	r = httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(carrier)
+	r.AddCookie(responseCookie(page, oasis.CSRFTokenParam))
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); !strings.Contains(location, "error=server_error") {
+		t.Errorf("expected server_error redirection, got %#v", location)
+	}
+}
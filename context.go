@@ -1,28 +1,191 @@
 package oasis
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 // TokenFactory is the interface to produce different
 // token strings with given context.
-type TokenFactory interface{} // TODO: define me
+type TokenFactory interface {
+
+	// NewToken produces a new token of the given type for the
+	// authorized request. The token expires after expiresIn,
+	// which must be positive.
+	//
+	// The request's ClientID, UserID and Scope are copied
+	// to the returned *Token.
+	NewToken(
+		ctx context.Context,
+		tokenType TokenType,
+		ar *AuthorizeRequest,
+		expiresIn time.Duration,
+	) (*Token, error)
+}
 
 // TokenStorage is the interface to retrieve all tokens includes,
 // 1. Authorization Code (in Authorization Code Grant); and
 // 2. Access Token (in Token Response); and
 // 3. Refresh Token (in Token Response).
-type TokenStorage interface{} // TODO: define me
+//
+// Tokens are identified by their Type and Value. An expired
+// token should be treated as if it does not exist.
+type TokenStorage interface {
+
+	// SaveToken stores the token. A token of the same
+	// type and value will be overwritten.
+	SaveToken(ctx context.Context, token *Token) error
+
+	// GetToken retrieves a token of the given type by its value.
+	// Returns ErrTokenNotFound if there is no such token, or if
+	// the token has expired.
+	GetToken(ctx context.Context, tokenType TokenType, value string) (*Token, error)
+
+	// DeleteToken removes (i.e. revokes) a token of the given type.
+	// Returns ErrTokenNotFound if there is no such token.
+	//
+	// Implementations should make sure only one of the concurrent
+	// calls with the same token succeeds, so DeleteToken can be used
+	// to enforce one time use of a token.
+	DeleteToken(ctx context.Context, tokenType TokenType, value string) error
+
+	// GetTokensByClient retrieves all unexpired tokens
+	// issued to the given client.
+	GetTokensByClient(ctx context.Context, clientID string) ([]*Token, error)
+
+	// GetTokensByUser retrieves all unexpired tokens
+	// authorized by the given user.
+	GetTokensByUser(ctx context.Context, userID string) ([]*Token, error)
+}
+
+// ErrTokenNotFound is returned by TokenStorage when the
+// requested token does not exist or has expired.
+var ErrTokenNotFound = errors.New("token not found")
+
+// Default lifetime of tokens if not set in Context.
+const (
+	DefaultAuthorizationCodeTTL = 10 * time.Minute
+	DefaultAccessTokenTTL       = time.Hour
+)
 
 // Context provides full handling of token
 // creation and storage.
+//
+// If TokenFactory is not set, the endpoints will
+// use the one returned by NewTokenFactory with
+// default settings.
 type Context struct {
 	TokenStorage
 	TokenFactory
+
+	// ClientStore, if set, is used to validate client_id and
+	// redirect_uri of authorization requests, and to
+	// authenticate clients at the token endpoint.
+	ClientStore
+
+	// AuthorizationCodeTTL is the lifetime of authorization
+	// codes. RFC6749 section 4.1.2 recommends a maximum of
+	// 10 minutes. Default is DefaultAuthorizationCodeTTL.
+	AuthorizationCodeTTL time.Duration
+
+	// AccessTokenTTL is the lifetime of access tokens.
+	// Default is DefaultAccessTokenTTL.
+	AccessTokenTTL time.Duration
+
+	// RefreshTokenTTL is the lifetime of refresh tokens.
+	// No refresh token will be issued if not set.
+	RefreshTokenTTL time.Duration
+
+	// DisableImplicitGrant rejects the implicit grant (i.e.
+	// response_type "token") even if it is allowed by the
+	// AuthorizeDecoder, as OAuth 2.1 omits it.
+	DisableImplicitGrant bool
+
+	// Issuer is the issuer identifier of the authorization
+	// server, i.e. the "iss" claim of ID Tokens.
+	Issuer string
+
+	// IDTokenSigner, if set, signs ID Tokens. An ID Token is
+	// issued with the access token if the openid scope is
+	// granted (OpenID Connect Core 1.0 section 3.1.3.3).
+	IDTokenSigner JWTSigner
+
+	// IDTokenTTL is the lifetime of ID Tokens.
+	// Default is DefaultIDTokenTTL.
+	IDTokenTTL time.Duration
+
+	// UserInfoSigner, if set, signs the UserInfo Response as
+	// a JWT (OpenID Connect Core 1.0 section 5.3.2).
+	UserInfoSigner JWTSigner
+
+	// ClaimsProvider, if set, provides the claims about users
+	// requested by the granted scope (see ScopeClaims).
+	ClaimsProvider ClaimsProvider
+
+	// PKCEPolicy returns the PKCE policy of the given client.
+	// If not set, the Client.PKCE of ClientStore is used. If
+	// neither is set, PKCE is optional to all clients.
+	PKCEPolicy func(ctx context.Context, clientID string) PKCEPolicy
+
+	// ScopeValidator limits the scope of Authorization Requests
+	// decoded by the default AuthorizeDecoder. If not set,
+	// DefaultScopeValidator is used.
+	ScopeValidator ScopeValidator
+
+	// PendingAuthorizationStore, if set, is used by the default
+	// AuthorizeDecoder to restore AuthorizeRequest pending on
+	// server side in the later stages.
+	PendingAuthorizationStore
+
+	// StateSealer, if set, is used by the default AuthorizeDecoder
+	// to restore AuthorizeRequest sealed in the later stages.
+	StateSealer StateSealer
+
+	// ReplayCache is required with StateSealer, so that a sealed
+	// AuthorizeRequest gets only one Authorization Response.
+	ReplayCache ReplayCache
+
+	// Logger, if set, logs the outcome of every request to the
+	// endpoints. Secrets (e.g. codes, tokens and client secrets)
+	// are redacted.
+	Logger Logger
+}
+
+// withDefaults returns a copy of the Context with
+// unset fields filled by the default implementations.
+func (actx Context) withDefaults() Context {
+	if actx.TokenFactory == nil {
+		actx.TokenFactory = NewTokenFactory(0, nil)
+	}
+	if actx.AuthorizationCodeTTL == 0 {
+		actx.AuthorizationCodeTTL = DefaultAuthorizationCodeTTL
+	}
+	if actx.AccessTokenTTL == 0 {
+		actx.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if actx.IDTokenTTL == 0 {
+		actx.IDTokenTTL = DefaultIDTokenTTL
+	}
+	return actx
+}
+
+// contextWithDefaults gets an *oasis.Context from a context.Context
+// with unset fields filled by the default implementations.
+func contextWithDefaults(ctx context.Context) *Context {
+	actx := GetContext(ctx)
+	if actx == nil {
+		return nil
+	}
+	filled := actx.withDefaults()
+	return &filled
 }
 
 type contextKey int
 
 const (
 	contextContext contextKey = iota
+	contextStageTransitions
 )
 
 // WithContext embeds an *oasis.Context into a context.Context
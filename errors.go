@@ -0,0 +1,268 @@
+package oasis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrorCode is the error code of an OAuth2 Error Response.
+type ErrorCode string
+
+// Error codes of authorization endpoint Error Response as
+// described in RFC6749 section 4.1.2.1 and 4.2.2.1, and of
+// token endpoint Error Response as described in RFC6749
+// section 5.2.
+const (
+	ErrInvalidRequest          ErrorCode = "invalid_request"
+	ErrUnauthorizedClient      ErrorCode = "unauthorized_client"
+	ErrAccessDenied            ErrorCode = "access_denied"
+	ErrUnsupportedResponseType ErrorCode = "unsupported_response_type"
+	ErrInvalidScope            ErrorCode = "invalid_scope"
+	ErrServerError             ErrorCode = "server_error"
+	ErrTemporarilyUnavailable  ErrorCode = "temporarily_unavailable"
+	ErrInvalidClient           ErrorCode = "invalid_client"
+	ErrInvalidGrant            ErrorCode = "invalid_grant"
+	ErrUnsupportedGrantType    ErrorCode = "unsupported_grant_type"
+)
+
+// Error codes of authorization endpoint Error Response as
+// described in OpenID Connect Core 1.0 section 3.1.2.6.
+const (
+	ErrInteractionRequired      ErrorCode = "interaction_required"
+	ErrLoginRequired            ErrorCode = "login_required"
+	ErrAccountSelectionRequired ErrorCode = "account_selection_required"
+	ErrConsentRequired          ErrorCode = "consent_required"
+)
+
+// Error codes of protected resource Error Response as
+// described in RFC6750 section 3.1.
+const (
+	ErrInvalidToken      ErrorCode = "invalid_token"
+	ErrInsufficientScope ErrorCode = "insufficient_scope"
+)
+
+// Error represents an OAuth2 error as described in
+// RFC6749 section 4.1.2.1, 4.2.2.1 and 5.2.
+//
+// It implements ResponderError, so it can be returned by
+// a Responder and displayed by the ResponseEncoder.
+type Error struct {
+
+	// ErrorCode. REQUIRED. A single ASCII error code.
+	ErrorCode ErrorCode `json:"error"`
+
+	// Description. OPTIONAL. Human-readable ASCII text
+	// providing additional information.
+	Description string `json:"error_description,omitempty"`
+
+	// URI. OPTIONAL. A URI identifying a human-readable
+	// web page with information about the error.
+	URI string `json:"error_uri,omitempty"`
+
+	// StatusCode is the HTTP response code for the error.
+	// If not set, the default code of ErrorCode is used.
+	StatusCode int `json:"-"`
+}
+
+// NewError returns an *Error of the given code and description.
+func NewError(code ErrorCode, description string) *Error {
+	return &Error{
+		ErrorCode:   code,
+		Description: description,
+	}
+}
+
+// Error implements error
+func (err *Error) Error() string {
+	if err.Description == "" {
+		return string(err.ErrorCode)
+	}
+	return string(err.ErrorCode) + ": " + err.Description
+}
+
+// Code implements ResponderError. It returns the HTTP
+// response code of the error.
+func (err *Error) Code() int {
+	if err.StatusCode != 0 {
+		return err.StatusCode
+	}
+	switch err.ErrorCode {
+	case ErrInvalidClient, ErrInvalidToken:
+		return http.StatusUnauthorized
+	case ErrAccessDenied, ErrInsufficientScope:
+		return http.StatusForbidden
+	case ErrServerError:
+		return http.StatusInternalServerError
+	case ErrTemporarilyUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// Message implements ResponderError. It returns a
+// user understandable message of the error code.
+func (err *Error) Message() string {
+	switch err.ErrorCode {
+	case ErrInvalidRequest:
+		return "The request is missing a required parameter or is otherwise malformed."
+	case ErrUnauthorizedClient:
+		return "The client is not authorized to make this request."
+	case ErrAccessDenied:
+		return "The request is denied."
+	case ErrUnsupportedResponseType:
+		return "The requested response type is not supported."
+	case ErrInvalidScope:
+		return "The requested scope is invalid or unknown."
+	case ErrServerError:
+		return "The server encountered an unexpected error."
+	case ErrTemporarilyUnavailable:
+		return "The server is temporarily unable to handle the request."
+	case ErrInvalidClient:
+		return "The client authentication failed."
+	case ErrInvalidGrant:
+		return "The authorization grant is invalid or expired."
+	case ErrUnsupportedGrantType:
+		return "The requested grant type is not supported."
+	case ErrInteractionRequired:
+		return "The request requires user interaction."
+	case ErrLoginRequired:
+		return "The request requires user authentication."
+	case ErrAccountSelectionRequired:
+		return "The request requires the user to select an account."
+	case ErrConsentRequired:
+		return "The request requires user consent."
+	case ErrInvalidToken:
+		return "The access token is invalid or expired."
+	case ErrInsufficientScope:
+		return "The access token is not granted the required scope."
+	}
+	return "The request failed."
+}
+
+// ErrorResponse is a Responder to output an *Error as a
+// JSON Error Response as described in RFC6749 section 5.2.
+type ErrorResponse struct {
+
+	// HeaderCache stores the response http header
+	HeaderCache http.Header
+
+	// Err is the error to output.
+	Err *Error
+}
+
+// ResponseTo implements Responder interface
+func (er *ErrorResponse) ResponseTo(w http.ResponseWriter) error {
+	for key, values := range er.HeaderCache {
+		for i := range values {
+			w.Header().Add(key, values[i])
+		}
+	}
+	return writeJSON(w, er.Err.Code(), er.Err)
+}
+
+// writeJSON writes v as a JSON response that must
+// not be cached (RFC6749 section 5.1).
+func writeJSON(w http.ResponseWriter, code int, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(code)
+	_, err = w.Write(content)
+	return err
+}
+
+// NewAuthorizeErrorResponse returns the Error Response of an
+// authorization request as described in RFC6749 section 4.1.2.1
+// and 4.2.2.1.
+//
+// The error is redirected to the client with error,
+// error_description, error_uri and state. The parameters are
+// encoded in the fragment component if the response_type
+// includes "token" or "id_token", or in the query component
+// otherwise.
+//
+// The error is only redirected if the redirect_uri can be
+// trusted, i.e. the client and redirect_uri are found in
+// Context.ClientStore. Otherwise, the returned Responder
+// leaves the error to be displayed by the ResponseEncoder.
+//
+// If err is not an *Error, it is reported as "server_error"
+// without description.
+func NewAuthorizeErrorResponse(ctx context.Context, ar *AuthorizeRequest, err error) Responder {
+	oerr, ok := err.(*Error)
+	if !ok {
+		oerr = NewError(ErrServerError, "")
+	}
+	if ar == nil || !redirectURITrusted(ctx, ar) {
+		return &errorPageResponse{err: oerr}
+	}
+
+	params := url.Values{"error": {string(oerr.ErrorCode)}}
+	if oerr.Description != "" {
+		params.Set("error_description", oerr.Description)
+	}
+	if oerr.URI != "" {
+		params.Set("error_uri", oerr.URI)
+	}
+	if ar.State != "" {
+		params.Set("state", ar.State)
+	}
+
+	rr := &RedirectResponse{
+		HeaderCache: make(http.Header),
+		RedirectURI: ar.RedirectURI,
+	}
+	if fragmentEncoded(ar.ResponseType) {
+		rr.Fragment = params
+	} else {
+		rr.Query = params
+	}
+	return rr
+}
+
+// redirectURITrusted reports whether the client of the request
+// is registered in Context.ClientStore with the redirect_uri.
+func redirectURITrusted(ctx context.Context, ar *AuthorizeRequest) bool {
+	if ar.ClientID == "" || ar.RedirectURI == "" {
+		return false
+	}
+	actx := GetContext(ctx)
+	if actx == nil || actx.ClientStore == nil {
+		return false
+	}
+	client, err := actx.GetClient(ctx, ar.ClientID)
+	if err != nil {
+		return false
+	}
+	return client.HasRedirectURI(ar.RedirectURI)
+}
+
+// fragmentEncoded reports whether the response of the response_type
+// is encoded in the fragment component (RFC6749 section 4.2.2, and
+// OAuth 2.0 Multiple Response Type Encoding Practices section 5).
+func fragmentEncoded(responseType string) bool {
+	for _, value := range strings.Fields(responseType) {
+		if value == "token" || value == "id_token" {
+			return true
+		}
+	}
+	return false
+}
+
+// errorPageResponse is a Responder that always returns
+// its error, so the error is displayed by the ResponseEncoder.
+type errorPageResponse struct {
+	err *Error
+}
+
+// ResponseTo implements Responder interface
+func (rsp *errorPageResponse) ResponseTo(w http.ResponseWriter) error {
+	return rsp.err
+}
@@ -0,0 +1,192 @@
+package oasis_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		err             *oasis.Error
+		expectedCode    int
+		expectedMessage string
+		expectedError   string
+	}{
+		{
+			err:             oasis.NewError(oasis.ErrInvalidRequest, "client_id is required but not set"),
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: "The request is missing a required parameter or is otherwise malformed.",
+			expectedError:   "invalid_request: client_id is required but not set",
+		},
+		{
+			err:             oasis.NewError(oasis.ErrAccessDenied, ""),
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: "The request is denied.",
+			expectedError:   "access_denied",
+		},
+		{
+			err:             oasis.NewError(oasis.ErrServerError, "database is down"),
+			expectedCode:    http.StatusInternalServerError,
+			expectedMessage: "The server encountered an unexpected error.",
+			expectedError:   "server_error: database is down",
+		},
+		{
+			err:             oasis.NewError(oasis.ErrTemporarilyUnavailable, ""),
+			expectedCode:    http.StatusServiceUnavailable,
+			expectedMessage: "The server is temporarily unable to handle the request.",
+			expectedError:   "temporarily_unavailable",
+		},
+		{
+			err: &oasis.Error{
+				ErrorCode:  oasis.ErrInvalidRequest,
+				StatusCode: http.StatusMethodNotAllowed,
+			},
+			expectedCode:    http.StatusMethodNotAllowed,
+			expectedMessage: "The request is missing a required parameter or is otherwise malformed.",
+			expectedError:   "invalid_request",
+		},
+	}
+
+	for _, test := range tests {
+		var rsprErr oasis.ResponderError = test.err
+		if want, have := test.expectedCode, rsprErr.Code(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.err.ErrorCode, want, have)
+		}
+		if want, have := test.expectedMessage, rsprErr.Message(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.err.ErrorCode, want, have)
+		}
+		if want, have := test.expectedError, rsprErr.Error(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.err.ErrorCode, want, have)
+		}
+	}
+}
+
+type errorResponder struct{ err error }
+
+func (rspr errorResponder) ResponseTo(w http.ResponseWriter) error {
+	return rspr.err
+}
+
+func TestError_ResponseEncoder(t *testing.T) {
+	w := httptest.NewRecorder()
+	oasis.NewResponseEncoder().EncodeResponse(w, errorResponder{
+		err: oasis.NewError(oasis.ErrUnsupportedResponseType, `response_type "token" is not allowed`),
+	})
+
+	if want, have := http.StatusBadRequest, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "The requested response type is not supported.", w.Body.String(); !strings.Contains(have, want) {
+		t.Errorf("expected body to contain %#v, got %#v", want, have)
+	}
+}
+
+func TestNewAuthorizeErrorResponse(t *testing.T) {
+	actx := &oasis.Context{ClientStore: newTestClientStore()}
+	ctx := oasis.WithContext(context.Background(), actx)
+
+	tests := []struct {
+		desc             string
+		ctx              context.Context
+		ar               *oasis.AuthorizeRequest
+		err              error
+		expectedLocation string
+		expectedCode     int
+	}{
+		{
+			desc: "code flow in query",
+			ctx:  ctx,
+			ar: &oasis.AuthorizeRequest{
+				ResponseType: "code",
+				ClientID:     "web-client",
+				RedirectURI:  "https://web.foobar.com/cb",
+				State:        "dummy-state",
+			},
+			err:              oasis.NewError(oasis.ErrAccessDenied, "user denied the request"),
+			expectedLocation: "https://web.foobar.com/cb?error=access_denied&error_description=user+denied+the+request&state=dummy-state",
+		},
+		{
+			desc: "implicit flow in fragment",
+			ctx:  ctx,
+			ar: &oasis.AuthorizeRequest{
+				ResponseType: "token",
+				ClientID:     "web-client",
+				RedirectURI:  "https://web.foobar.com/cb",
+				State:        `a%b"c d`,
+			},
+			err:              oasis.NewError(oasis.ErrInvalidScope, "scope is not allowed"),
+			expectedLocation: "https://web.foobar.com/cb#error=invalid_scope&error_description=scope+is+not+allowed&state=a%25b%22c+d",
+		},
+		{
+			desc: "non oauth error",
+			ctx:  ctx,
+			ar: &oasis.AuthorizeRequest{
+				ResponseType: "code",
+				ClientID:     "web-client",
+				RedirectURI:  "https://web.foobar.com/cb",
+			},
+			err:              fmt.Errorf("database is down"),
+			expectedLocation: "https://web.foobar.com/cb?error=server_error",
+		},
+		{
+			desc: "unregistered redirect_uri",
+			ctx:  ctx,
+			ar: &oasis.AuthorizeRequest{
+				ResponseType: "code",
+				ClientID:     "web-client",
+				RedirectURI:  "https://evil.foobar.com/cb",
+			},
+			err:          oasis.NewError(oasis.ErrInvalidRequest, "redirect_uri is not registered for the client"),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc: "unknown client",
+			ctx:  ctx,
+			ar: &oasis.AuthorizeRequest{
+				ResponseType: "code",
+				ClientID:     "evil-client",
+				RedirectURI:  "https://evil.foobar.com/cb",
+			},
+			err:          oasis.NewError(oasis.ErrInvalidRequest, `client_id "evil-client" is not registered`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			desc: "no client store",
+			ctx:  context.Background(),
+			ar: &oasis.AuthorizeRequest{
+				ResponseType: "code",
+				ClientID:     "web-client",
+				RedirectURI:  "https://web.foobar.com/cb",
+			},
+			err:          oasis.NewError(oasis.ErrAccessDenied, ""),
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		oasis.NewResponseEncoder().EncodeResponse(w, oasis.NewAuthorizeErrorResponse(test.ctx, test.ar, test.err))
+
+		if test.expectedLocation != "" {
+			if want, have := http.StatusTemporaryRedirect, w.Code; want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+			}
+			if want, have := test.expectedLocation, w.Header().Get("Location"); want != have {
+				t.Errorf("%s:\nexpected: %s\ngot:      %s", test.desc, want, have)
+			}
+			continue
+		}
+		if want, have := test.expectedCode, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if have := w.Header().Get("Location"); have != "" {
+			t.Errorf("%s: expected no redirection, got %#v", test.desc, have)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/go-oasis/oasis
+
+go 1.21
@@ -0,0 +1,66 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// NewImplicitTokenResponse issues an Access Token for a
+// successfully authorized implicit grant request, and returns the
+// Access Token Response as described in RFC6749 section 4.2.2.
+//
+// The access token is produced by the TokenFactory and saved to
+// the TokenStorage of the *Context in ctx. The parameters are
+// encoded in the fragment of the redirection URI. No refresh
+// token is issued (RFC6749 section 4.2.2).
+//
+// An error is returned if Context.DisableImplicitGrant is set.
+func NewImplicitTokenResponse(ctx context.Context, ar *AuthorizeRequest) (rr *RedirectResponse, err error) {
+	actx := contextWithDefaults(ctx)
+	if actx == nil || actx.TokenStorage == nil {
+		err = fmt.Errorf("token storage is required but not set in context")
+		return
+	}
+	if actx.DisableImplicitGrant {
+		err = NewError(ErrUnsupportedResponseType, "implicit grant is disabled")
+		return
+	}
+	if ar.ResponseType != "token" {
+		err = fmt.Errorf(`response_type "%s" is not "token"`, ar.ResponseType)
+		return
+	}
+	if ar.UserID == "" {
+		err = fmt.Errorf("authorize request is not authenticated")
+		return
+	}
+
+	token, err := actx.NewToken(ctx, TokenTypeAccessToken, ar, actx.AccessTokenTTL)
+	if err != nil {
+		return
+	}
+	if err = actx.SaveToken(ctx, token); err != nil {
+		return
+	}
+
+	fragment := url.Values{
+		"access_token": {token.Value},
+		"token_type":   {"Bearer"},
+		"expires_in":   {strconv.FormatInt(int64(actx.AccessTokenTTL/time.Second), 10)},
+	}
+	if ar.Scope != "" {
+		fragment.Set("scope", ar.Scope)
+	}
+	if ar.State != "" {
+		fragment.Set("state", ar.State)
+	}
+	rr = &RedirectResponse{
+		HeaderCache: make(http.Header),
+		RedirectURI: ar.RedirectURI,
+		Fragment:    fragment,
+	}
+	return
+}
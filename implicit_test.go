@@ -0,0 +1,123 @@
+package oasis_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func newImplicitEndpoint(actx oasis.Context) http.Handler {
+	actx.ClientStore = newTestClientStore()
+	return oasis.NewAuthorizeEndpoint(
+		actx,
+		oasis.NewAuthorizeDecoder("code", "token"),
+		oasis.AuthorizeHandlerFunc(func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+			if decodeErr != nil {
+				return oasis.NewAuthorizeErrorResponse(ctx, ar, decodeErr)
+			}
+			ar.UserID = "dummy-user"
+			rr, err := oasis.NewImplicitTokenResponse(ctx, ar)
+			if err != nil {
+				return oasis.NewAuthorizeErrorResponse(ctx, ar, err)
+			}
+			return rr
+		}),
+		oasis.NewResponseEncoder(),
+	)
+}
+
+func TestNewImplicitTokenResponse(t *testing.T) {
+	storage := oasis.NewMemoryTokenStorage()
+	endpoint := newImplicitEndpoint(oasis.Context{TokenStorage: storage})
+
+	state := `a%b"c d+e=f&g`
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=token&client_id=web-client&scope=read&state="+url.QueryEscape(state), nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if location.RawQuery != "" {
+		t.Errorf("expected no query, got %#v", location.RawQuery)
+	}
+	fragment, err := url.ParseQuery(location.EscapedFragment())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]string{
+		"token_type": "Bearer",
+		"expires_in": "3600",
+		"scope":      "read",
+		"state":      state,
+	}
+	for name, value := range expected {
+		if want, have := value, fragment.Get(name); want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+	if _, ok := fragment["refresh_token"]; ok {
+		t.Errorf("unexpected refresh_token")
+	}
+
+	// expires_in is the configured lifetime
+	w = httptest.NewRecorder()
+	newImplicitEndpoint(oasis.Context{TokenStorage: storage, AccessTokenTTL: 90 * time.Second}).ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=token&client_id=web-client", nil))
+	location, _ = url.Parse(w.Header().Get("Location"))
+	fragment, _ = url.ParseQuery(location.EscapedFragment())
+	if want, have := "90", fragment.Get("expires_in"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	token, err := storage.GetToken(context.Background(), oasis.TokenTypeAccessToken, fragment.Get("access_token"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "dummy-user", token.UserID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// response_type "code" is not an implicit request
+	ctx := oasis.WithContext(context.Background(), &oasis.Context{TokenStorage: storage})
+	if _, err := oasis.NewImplicitTokenResponse(ctx, &oasis.AuthorizeRequest{ResponseType: "code", UserID: "dummy-user"}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestNewImplicitTokenResponse_disabled(t *testing.T) {
+	endpoint := newImplicitEndpoint(oasis.Context{
+		TokenStorage:         oasis.NewMemoryTokenStorage(),
+		DisableImplicitGrant: true,
+	})
+
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=token&client_id=web-client&state=dummy-state", nil))
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "https://web.foobar.com/cb#error=unsupported_response_type") {
+		t.Errorf("expected unsupported_response_type redirection, got %#v", location)
+	}
+
+	ctx := oasis.WithContext(context.Background(), &oasis.Context{
+		TokenStorage:         oasis.NewMemoryTokenStorage(),
+		DisableImplicitGrant: true,
+	})
+	_, err := oasis.NewImplicitTokenResponse(ctx, &oasis.AuthorizeRequest{ResponseType: "token", UserID: "dummy-user"})
+	if want, have := "unsupported_response_type: implicit grant is disabled", err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	metadata := oasis.NewMetadata(oasis.Context{DisableImplicitGrant: true}, oasis.MetadataConfig{
+		AuthorizeDecoder: oasis.NewAuthorizeDecoder("code", "token"),
+	})
+	if want, have := "code", strings.Join(metadata.ResponseTypesSupported, " "); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "authorization_code", strings.Join(metadata.GrantTypesSupported, " "); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
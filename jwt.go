@@ -0,0 +1,264 @@
+package oasis
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// JWS algorithms (RFC7518 section 3.1 and RFC8037 section 3.1)
+// supported by SigningKey.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// JWTSigner signs JSON Web Tokens (RFC7519) in
+// JWS compact serialization (RFC7515 section 7.1).
+type JWTSigner interface {
+
+	// Algorithm returns the JWS "alg" the signer uses.
+	Algorithm() string
+
+	// SignJWT encodes claims as JSON and signs it. The
+	// typ, if not empty, is set as the "typ" header.
+	SignJWT(typ string, claims interface{}) (string, error)
+}
+
+// SigningKey is a key to sign JSON Web Tokens.
+// It implements JWTSigner.
+type SigningKey struct {
+
+	// ID is the key id to be set as the "kid" header,
+	// if not empty.
+	ID string
+
+	// alg is the JWS algorithm of the key.
+	alg string
+
+	// key is a []byte for HS256, or a crypto.Signer
+	// for other algorithms.
+	key interface{}
+}
+
+// NewSigningKey returns a *SigningKey of the given key id. The
+// algorithm is chosen by the type of key:
+//
+// 1. []byte of at least 32 bytes for HS256,
+// 2. crypto.Signer of *rsa.PublicKey for RS256,
+// 3. crypto.Signer of P-256 *ecdsa.PublicKey for ES256,
+// 4. crypto.Signer of ed25519.PublicKey for EdDSA.
+//
+// *rsa.PrivateKey, *ecdsa.PrivateKey and ed25519.PrivateKey
+// are all crypto.Signer.
+func NewSigningKey(id string, key interface{}) (*SigningKey, error) {
+	switch k := key.(type) {
+	case []byte:
+		if len(k) < sha256.Size {
+			return nil, fmt.Errorf("HS256 key must be at least %d bytes, got %d", sha256.Size, len(k))
+		}
+		return &SigningKey{ID: id, alg: AlgorithmHS256, key: k}, nil
+	case crypto.Signer:
+		switch pub := k.Public().(type) {
+		case *rsa.PublicKey:
+			if pub.N.BitLen() < 2048 {
+				return nil, fmt.Errorf("RS256 key must be at least 2048 bits, got %d", pub.N.BitLen())
+			}
+			return &SigningKey{ID: id, alg: AlgorithmRS256, key: k}, nil
+		case *ecdsa.PublicKey:
+			if pub.Curve.Params().Name != "P-256" {
+				return nil, fmt.Errorf("ES256 key must be on curve P-256, got %s", pub.Curve.Params().Name)
+			}
+			return &SigningKey{ID: id, alg: AlgorithmES256, key: k}, nil
+		case ed25519.PublicKey:
+			return &SigningKey{ID: id, alg: AlgorithmEdDSA, key: k}, nil
+		}
+		return nil, fmt.Errorf("unsupported public key type %T", k.Public())
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// Algorithm implements JWTSigner
+func (key *SigningKey) Algorithm() string {
+	return key.alg
+}
+
+// SignJWT implements JWTSigner
+func (key *SigningKey) SignJWT(typ string, claims interface{}) (token string, err error) {
+	header := map[string]string{"alg": key.alg}
+	if typ != "" {
+		header["typ"] = typ
+	}
+	if key.ID != "" {
+		header["kid"] = key.ID
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		err = fmt.Errorf("unable to encode claims. %s", err.Error())
+		return
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) +
+		"." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signature, err := key.sign([]byte(signingInput))
+	if err != nil {
+		err = fmt.Errorf("unable to sign token. %s", err.Error())
+		return
+	}
+	token = signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return
+}
+
+// sign produces the JWS signature of the signing input.
+func (key *SigningKey) sign(input []byte) ([]byte, error) {
+	if key.alg == AlgorithmHS256 {
+		mac := hmac.New(sha256.New, key.key.([]byte))
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	}
+
+	signer := key.key.(crypto.Signer)
+	if key.alg == AlgorithmEdDSA {
+		// Ed25519 signs the message itself instead of the digest
+		return signer.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(input)
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil || key.alg != AlgorithmES256 {
+		return signature, err
+	}
+
+	// crypto.Signer returns ASN.1 DER encoded ECDSA signature,
+	// but JWS expects the fixed size R || S (RFC7518 section 3.4).
+	var parsed struct{ R, S *big.Int }
+	if _, err = asn1.Unmarshal(signature, &parsed); err != nil {
+		return nil, err
+	}
+	size := (signer.Public().(*ecdsa.PublicKey).Curve.Params().BitSize + 7) / 8
+	signature = make([]byte, 2*size)
+	parsed.R.FillBytes(signature[:size])
+	parsed.S.FillBytes(signature[size:])
+	return signature, nil
+}
+
+// JWTAccessTokenClaims is the JWT claims set of an access
+// token as described in RFC9068 section 2.2.
+type JWTAccessTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  []string `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	JWTID     string   `json:"jti"`
+	ClientID  string   `json:"client_id"`
+	Scope     string   `json:"scope,omitempty"`
+}
+
+// JWTTokenFactory is a TokenFactory that produces access tokens
+// as JSON Web Tokens (RFC9068). Resource servers may validate
+// these tokens locally with the public key of the signer.
+//
+// Authorization codes and refresh tokens are not meant to be
+// read by resource servers. They are produced as opaque tokens
+// by the default TokenFactory.
+type JWTTokenFactory struct {
+	signer   JWTSigner
+	issuer   string
+	audience []string
+	opaque   TokenFactory
+}
+
+// NewToken implements TokenFactory.
+func (tf *JWTTokenFactory) NewToken(ctx context.Context, tokenType TokenType, ar *AuthorizeRequest, expiresIn time.Duration) (token *Token, err error) {
+	if tokenType != TokenTypeAccessToken {
+		return tf.opaque.NewToken(ctx, tokenType, ar, expiresIn)
+	}
+	if ar == nil {
+		err = fmt.Errorf("authorize request is required but not set")
+		return
+	}
+	if expiresIn <= 0 {
+		err = fmt.Errorf("token lifetime must be positive, got %s", expiresIn)
+		return
+	}
+
+	jti := make([]byte, 16)
+	if _, err = rand.Read(jti); err != nil {
+		err = fmt.Errorf("unable to read random bytes. %s", err.Error())
+		return
+	}
+
+	// RFC9068 section 2.2: the subject is the client itself
+	// if no resource owner is involved.
+	subject := ar.UserID
+	if subject == "" {
+		subject = ar.ClientID
+	}
+
+	// RFC9068 section 2.2: aud is required. Without a
+	// configured resource, the client is the audience.
+	audience := tf.audience
+	if len(audience) == 0 {
+		audience = []string{ar.ClientID}
+	}
+
+	// JWT NumericDate has a resolution of seconds
+	now := time.Now().Truncate(time.Second)
+	claims := &JWTAccessTokenClaims{
+		Issuer:    tf.issuer,
+		Subject:   subject,
+		Audience:  audience,
+		ExpiresAt: now.Add(expiresIn).Unix(),
+		IssuedAt:  now.Unix(),
+		JWTID:     base64.RawURLEncoding.EncodeToString(jti),
+		ClientID:  ar.ClientID,
+		Scope:     ar.Scope,
+	}
+	value, err := tf.signer.SignJWT("at+jwt", claims)
+	if err != nil {
+		return
+	}
+
+	token = &Token{
+		Type:      tokenType,
+		Value:     value,
+		ClientID:  ar.ClientID,
+		UserID:    ar.UserID,
+		Scope:     ar.Scope,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(expiresIn),
+	}
+	return
+}
+
+// NewJWTTokenFactory returns a TokenFactory which produces
+// JWT access tokens signed by signer, with "iss" claim set to
+// issuer and "aud" claim set to audience. If no audience is
+// given, "aud" is set to the client_id of the request.
+func NewJWTTokenFactory(signer JWTSigner, issuer string, audience ...string) TokenFactory {
+	return &JWTTokenFactory{
+		signer:   signer,
+		issuer:   issuer,
+		audience: audience,
+		opaque:   NewTokenFactory(0, nil),
+	}
+}
@@ -0,0 +1,172 @@
+package oasis_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+// verifyJWT verifies the signature of a JWT signed with the
+// given key, and returns the decoded header and claims.
+func verifyJWT(t *testing.T, token string, key interface{}) (header, claims map[string]interface{}) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts in token, got %d", len(parts))
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256(input)
+
+	verified := false
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(input)
+		verified = hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PrivateKey:
+		verified = rsa.VerifyPKCS1v15(&k.PublicKey, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PrivateKey:
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		verified = len(signature) == 64 && ecdsa.Verify(&k.PublicKey, digest[:], r, s)
+	case ed25519.PrivateKey:
+		verified = ed25519.Verify(k.Public().(ed25519.PublicKey), input, signature)
+	}
+	if !verified {
+		t.Errorf("signature of %T is not verified", key)
+	}
+
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(headerJSON, &header)
+	json.Unmarshal(claimsJSON, &claims)
+	return
+}
+
+func TestNewSigningKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	weakRSAKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		desc        string
+		key         interface{}
+		expectedAlg string
+	}{
+		{"hmac key", []byte(strings.Repeat("k", 32)), oasis.AlgorithmHS256},
+		{"short hmac key", []byte("short"), ""},
+		{"rsa key", rsaKey, oasis.AlgorithmRS256},
+		{"weak rsa key", weakRSAKey, ""},
+		{"P-256 key", ecKey, oasis.AlgorithmES256},
+		{"P-384 key", p384Key, ""},
+		{"ed25519 key", edKey, oasis.AlgorithmEdDSA},
+		{"string key", "not a key", ""},
+	}
+
+	for _, test := range tests {
+		key, err := oasis.NewSigningKey("kid-1", test.key)
+		if test.expectedAlg == "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+			continue
+		}
+		if want, have := test.expectedAlg, key.Algorithm(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+
+		token, err := key.SignJWT("JWT", map[string]string{"hello": "world"})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+			continue
+		}
+		header, claims := verifyJWT(t, token, test.key)
+		if want, have := test.expectedAlg, header["alg"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := "kid-1", header["kid"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := "world", claims["hello"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestJWTTokenFactory(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, _ := oasis.NewSigningKey("kid-1", ecKey)
+	factory := oasis.NewJWTTokenFactory(key, "https://auth.foobar.com", "https://api.foobar.com")
+
+	ar := &oasis.AuthorizeRequest{
+		ClientID: "dummy-client",
+		UserID:   "dummy-user",
+		Scope:    "read write",
+	}
+	token, err := factory.NewToken(context.Background(), oasis.TokenTypeAccessToken, ar, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	header, claims := verifyJWT(t, token.Value, ecKey)
+	if want, have := "at+jwt", header["typ"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for name, expected := range map[string]interface{}{
+		"iss":       "https://auth.foobar.com",
+		"sub":       "dummy-user",
+		"client_id": "dummy-client",
+		"scope":     "read write",
+		"exp":       float64(token.ExpiresAt.Unix()),
+		"iat":       float64(token.IssuedAt.Unix()),
+	} {
+		if want, have := expected, claims[name]; want != have {
+			t.Errorf("claim %s: expected %#v, got %#v", name, want, have)
+		}
+	}
+	if aud, ok := claims["aud"].([]interface{}); !ok || len(aud) != 1 || aud[0] != "https://api.foobar.com" {
+		t.Errorf("unexpected aud claim: %#v", claims["aud"])
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		t.Errorf("expected jti claim, got nothing")
+	}
+
+	// without audience, the client is the audience
+	token, err = oasis.NewJWTTokenFactory(key, "https://auth.foobar.com").NewToken(context.Background(), oasis.TokenTypeAccessToken, ar, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, claims = verifyJWT(t, token.Value, ecKey)
+	if aud, ok := claims["aud"].([]interface{}); !ok || len(aud) != 1 || aud[0] != "dummy-client" {
+		t.Errorf("unexpected aud claim: %#v", claims["aud"])
+	}
+
+	// refresh tokens are opaque
+	token, err = factory.NewToken(context.Background(), oasis.TokenTypeRefreshToken, ar, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(token.Value, ".") {
+		t.Errorf("expected opaque refresh token, got %#v", token.Value)
+	}
+}
@@ -0,0 +1,415 @@
+package oasis
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSMaxAge is the max-age of the JWK Set response
+// if the KeyManager has no RotationPeriod.
+const DefaultJWKSMaxAge = time.Hour
+
+// JWK is a public JSON Web Key as described in RFC7517
+// section 4, with the parameters of RFC7518 section 6 and
+// RFC8037 section 2.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP public key parameters
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKSet is a JWK Set as described in RFC7517 section 5.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the public JWK of the public key, with
+// "use" set to "sig". The key id is the JWK Thumbprint
+// (RFC7638) of the key.
+func NewJWK(pub crypto.PublicKey) (*JWK, error) {
+	var jwk *JWK
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk = &JWK{
+			KeyType:   "RSA",
+			Algorithm: AlgorithmRS256,
+			N:         base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 key must be on curve P-256, got %s", k.Curve.Params().Name)
+		}
+		// RFC7518 section 6.2.1.2: coordinates are the full
+		// size of the curve, including leading zeros.
+		x, y := make([]byte, 32), make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		jwk = &JWK{
+			KeyType:   "EC",
+			Algorithm: AlgorithmES256,
+			Curve:     "P-256",
+			X:         base64.RawURLEncoding.EncodeToString(x),
+			Y:         base64.RawURLEncoding.EncodeToString(y),
+		}
+	case ed25519.PublicKey:
+		jwk = &JWK{
+			KeyType:   "OKP",
+			Algorithm: AlgorithmEdDSA,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(k),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	jwk.Use = "sig"
+	jwk.KeyID = jwk.Thumbprint()
+	return jwk, nil
+}
+
+// Thumbprint returns the base64url encoded SHA-256 JWK
+// Thumbprint of the key as described in RFC7638 section 3.
+func (jwk *JWK) Thumbprint() string {
+	// RFC7638 section 3.2: only the required members,
+	// in lexicographic order, without whitespace.
+	var members []byte
+	switch jwk.KeyType {
+	case "RSA":
+		members, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N})
+	case "EC":
+		members, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y})
+	default:
+		members, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X})
+	}
+	sum := sha256.Sum256(members)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// managedKey is a signing key held by KeyManager.
+type managedKey struct {
+	signer      *SigningKey
+	jwk         *JWK
+	activatedAt time.Time
+
+	// retiredAt is the time the key stops signing,
+	// zero if the key is not retired.
+	retiredAt time.Time
+}
+
+// newManagedKey wraps the crypto.Signer as a managedKey
+// with its JWK Thumbprint as the key id.
+func newManagedKey(signer crypto.Signer) (*managedKey, error) {
+	jwk, err := NewJWK(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewSigningKey(jwk.KeyID, signer)
+	if err != nil {
+		return nil, err
+	}
+	return &managedKey{signer: key, jwk: jwk, activatedAt: time.Now()}, nil
+}
+
+// KeyManager holds the signing keys of the authorization
+// server and rotates them. It implements JWTSigner, so it can
+// be used as Context.IDTokenSigner or Context.UserInfoSigner,
+// and its public keys are published by NewJWKSEndpoint.
+//
+// It holds three kinds of keys:
+//
+//  1. the active key, which signs all tokens;
+//  2. the next key, which is published ahead of its activation,
+//     so verifiers caching the JWK Set already know it;
+//  3. the retired keys, which are published for RetentionPeriod
+//     after they stop signing, so tokens signed by them can still
+//     be verified.
+//
+// Keys are identified by their JWK Thumbprint (RFC7638) as
+// "kid". All signing goes through crypto.Signer, so keys held
+// by a KMS or an HSM can be added with AddKey.
+//
+// It is safe for concurrent use. The zero value generates its
+// keys on first use, and never rotates them.
+type KeyManager struct {
+
+	// Generate produces a new key on rotation. If not set, 2048
+	// bits RSA keys are generated for RS256.
+	Generate func() (crypto.Signer, error)
+
+	// RotationPeriod is the time a key is active before it is
+	// rotated. The rotation happens on the first signing or
+	// publication after the period. No scheduled rotation
+	// if zero.
+	RotationPeriod time.Duration
+
+	// RetentionPeriod is the time a retired key is kept for
+	// verification. It should be longer than the lifetime of
+	// the tokens signed.
+	RetentionPeriod time.Duration
+
+	mutex   sync.RWMutex
+	active  *managedKey
+	next    *managedKey
+	retired []*managedKey
+}
+
+// NewKeyManager returns a *KeyManager with active and next
+// keys produced by generate. If generate is nil, 2048 bits
+// RSA keys are generated for RS256.
+func NewKeyManager(generate func() (crypto.Signer, error), rotationPeriod, retentionPeriod time.Duration) (*KeyManager, error) {
+	km := &KeyManager{
+		Generate:        generate,
+		RotationPeriod:  rotationPeriod,
+		RetentionPeriod: retentionPeriod,
+	}
+	if err := km.Rotate(); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+// newKey produces a new managedKey with Generate.
+func (km *KeyManager) newKey() (*managedKey, error) {
+	generate := km.Generate
+	if generate == nil {
+		generate = func() (crypto.Signer, error) {
+			return rsa.GenerateKey(rand.Reader, 2048)
+		}
+	}
+	signer, err := generate()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate key. %s", err.Error())
+	}
+	return newManagedKey(signer)
+}
+
+// AddKey makes the signer the active key immediately, and
+// retires the current active key. The next key is kept.
+//
+// As verifiers may not know the new key yet, it should be
+// used for keys known to be published, e.g. keys restored
+// on restart, or keys held by a KMS.
+func (km *KeyManager) AddKey(signer crypto.Signer) error {
+	key, err := newManagedKey(signer)
+	if err != nil {
+		return err
+	}
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	km.activate(key)
+	return nil
+}
+
+// Rotate activates the next key, retires the active one, and
+// generates a new next key.
+func (km *KeyManager) Rotate() error {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	return km.rotate()
+}
+
+// rotate implements Rotate. The keys are generated while the caller
+// holds the write lock, so concurrent rotations generate them once.
+func (km *KeyManager) rotate() error {
+	next, err := km.newKey()
+	if err != nil {
+		return err
+	}
+	if km.next == nil {
+		// first rotation, there is no published next key yet
+		active, err := km.newKey()
+		if err != nil {
+			return err
+		}
+		km.next = active
+	}
+	km.activate(km.next)
+	km.next = next
+	return nil
+}
+
+// activate makes the key active and retires the active one.
+// Expired retired keys are removed. The caller must hold the
+// write lock.
+func (km *KeyManager) activate(key *managedKey) {
+	now := time.Now()
+	if km.active != nil {
+		km.active.retiredAt = now
+		km.retired = append(km.retired, km.active)
+	}
+	key.activatedAt = now
+	km.active = key
+
+	retired := km.retired[:0]
+	for _, key := range km.retired {
+		if now.Sub(key.retiredAt) < km.RetentionPeriod {
+			retired = append(retired, key)
+		}
+	}
+	km.retired = retired
+}
+
+// current returns the active key, rotating it first if there is
+// none, or if it has been active for more than RotationPeriod.
+// Should the rotation fail, the active key, if any, is kept
+// signing, and the rotation is retried on the next call.
+func (km *KeyManager) current() (*managedKey, error) {
+	km.mutex.RLock()
+	active := km.active
+	km.mutex.RUnlock()
+	if active != nil && !km.rotationDue(active) {
+		return active, nil
+	}
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	// rotated by another caller while waiting for the lock
+	if km.active != nil && !km.rotationDue(km.active) {
+		return km.active, nil
+	}
+	if err := km.rotate(); err != nil && km.active == nil {
+		return nil, err
+	}
+	return km.active, nil
+}
+
+// rotationDue reports whether the active key has been
+// active for more than RotationPeriod.
+func (km *KeyManager) rotationDue(active *managedKey) bool {
+	return km.RotationPeriod > 0 && time.Since(active.activatedAt) >= km.RotationPeriod
+}
+
+// Algorithm implements JWTSigner. Returns an empty string if
+// there is no active key and none can be generated.
+func (km *KeyManager) Algorithm() string {
+	key, err := km.current()
+	if err != nil {
+		return ""
+	}
+	return key.signer.Algorithm()
+}
+
+// SignJWT implements JWTSigner. The "kid" header is set to
+// the key id of the active key.
+func (km *KeyManager) SignJWT(typ string, claims interface{}) (string, error) {
+	key, err := km.current()
+	if err != nil {
+		return "", err
+	}
+	return key.signer.SignJWT(typ, claims)
+}
+
+// PublicKey returns the public key of the given key id, if it is
+// the active, next or an unexpired retired key. Otherwise nil.
+func (km *KeyManager) PublicKey(kid string) crypto.PublicKey {
+	km.current()
+	km.mutex.RLock()
+	defer km.mutex.RUnlock()
+	for _, key := range km.keys() {
+		if key.jwk.KeyID == kid {
+			return key.signer.key.(crypto.Signer).Public()
+		}
+	}
+	return nil
+}
+
+// JWKSet returns the public keys of the active, next and
+// unexpired retired keys, in that order.
+func (km *KeyManager) JWKSet() *JWKSet {
+	km.current()
+	km.mutex.RLock()
+	defer km.mutex.RUnlock()
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range km.keys() {
+		set.Keys = append(set.Keys, *key.jwk)
+	}
+	return set
+}
+
+// keys returns the active, next and unexpired retired keys,
+// if any. The caller must hold the lock.
+func (km *KeyManager) keys() []*managedKey {
+	var keys []*managedKey
+	for _, key := range []*managedKey{km.active, km.next} {
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	now := time.Now()
+	for i := len(km.retired) - 1; i >= 0; i-- {
+		if now.Sub(km.retired[i].retiredAt) < km.RetentionPeriod {
+			keys = append(keys, km.retired[i])
+		}
+	}
+	return keys
+}
+
+// NewJWKSEndpoint returns an http.Handler to publish the JWK Set
+// of the KeyManager, i.e. the document of "jwks_uri" in metadata.
+//
+// The response may be cached for the RotationPeriod of the
+// KeyManager (or DefaultJWKSMaxAge), as the next key is always
+// published one RotationPeriod ahead of its activation.
+func NewJWKSEndpoint(km *KeyManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		content, err := json.Marshal(km.JWKSet())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, NewError(ErrServerError, "unable to marshal key set"))
+			return
+		}
+
+		maxAge := km.RotationPeriod
+		if maxAge == 0 {
+			maxAge = DefaultJWKSMaxAge
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge/time.Second)))
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	})
+}
@@ -0,0 +1,273 @@
+package oasis_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+// newTestKeyManager returns a *KeyManager of ES256 keys, and
+// the generated private keys by their key id.
+func newTestKeyManager(t *testing.T, rotationPeriod, retentionPeriod time.Duration) (*oasis.KeyManager, map[string]*ecdsa.PrivateKey) {
+	generated := make(map[string]*ecdsa.PrivateKey)
+	km, err := oasis.NewKeyManager(func() (crypto.Signer, error) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		jwk, _ := oasis.NewJWK(key.Public())
+		generated[jwk.KeyID] = key
+		return key, nil
+	}, rotationPeriod, retentionPeriod)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return km, generated
+}
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// RFC7638 section 3.1
+	jwk := &oasis.JWK{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+		Use:     "sig",
+		KeyID:   "2011-04-29",
+	}
+	if want, have := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwk.Thumbprint(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	tests := []struct {
+		pub         crypto.PublicKey
+		expectedKty string
+		expectedAlg string
+	}{
+		{rsaKey.Public(), "RSA", oasis.AlgorithmRS256},
+		{ecKey.Public(), "EC", oasis.AlgorithmES256},
+		{edKey.Public(), "OKP", oasis.AlgorithmEdDSA},
+	}
+	for _, test := range tests {
+		jwk, err := oasis.NewJWK(test.pub)
+		if err != nil {
+			t.Errorf("%T: unexpected error: %s", test.pub, err)
+			continue
+		}
+		if want, have := test.expectedKty, jwk.KeyType; want != have {
+			t.Errorf("%T: expected %#v, got %#v", test.pub, want, have)
+		}
+		if want, have := test.expectedAlg, jwk.Algorithm; want != have {
+			t.Errorf("%T: expected %#v, got %#v", test.pub, want, have)
+		}
+		if want, have := jwk.Thumbprint(), jwk.KeyID; want != have {
+			t.Errorf("%T: expected %#v, got %#v", test.pub, want, have)
+		}
+	}
+	if jwk, _ := oasis.NewJWK(rsaKey.Public()); jwk.E != "AQAB" {
+		t.Errorf("expected %#v, got %#v", "AQAB", jwk.E)
+	}
+
+	if _, err := oasis.NewJWK(p384Key.Public()); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestKeyManager(t *testing.T) {
+	km, generated := newTestKeyManager(t, 0, time.Hour)
+	if want, have := oasis.AlgorithmES256, km.Algorithm(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	kids := func() (kids []string) {
+		for _, key := range km.JWKSet().Keys {
+			kids = append(kids, key.KeyID)
+		}
+		return
+	}
+	initial := kids()
+	if len(initial) != 2 {
+		t.Fatalf("expected active and next keys, got %#v", initial)
+	}
+
+	// signed by the active key
+	token, _ := km.SignJWT("JWT", map[string]string{"sub": "dummy-user"})
+	header, _ := verifyJWT(t, token, generated[initial[0]])
+	if want, have := initial[0], header["kid"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the published next key becomes active
+	// and the active key is retired
+	if err := km.Rotate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rotated := kids()
+	if len(rotated) != 3 || rotated[0] != initial[1] || rotated[2] != initial[0] {
+		t.Fatalf("unexpected keys %#v after rotation of %#v", rotated, initial)
+	}
+	if km.PublicKey(initial[0]) == nil {
+		t.Errorf("expected retired key to be kept for verification")
+	}
+	if km.PublicKey("unknown") != nil {
+		t.Errorf("expected no key for unknown kid")
+	}
+
+	// keys of a KMS are plugged in as crypto.Signer
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	if err := km.AddKey(edKey); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	token, _ = km.SignJWT("JWT", map[string]string{"sub": "dummy-user"})
+	header, _ = verifyJWT(t, token, edKey)
+	jwk, _ := oasis.NewJWK(edKey.Public())
+	if want, have := jwk.KeyID, header["kid"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestKeyManager_scheduled(t *testing.T) {
+	km, generated := newTestKeyManager(t, 5*time.Millisecond, 5*time.Millisecond)
+	initial := km.JWKSet().Keys
+
+	time.Sleep(10 * time.Millisecond)
+	token, _ := km.SignJWT("", map[string]string{"sub": "dummy-user"})
+	header, _ := verifyJWT(t, token, generated[initial[1].KeyID])
+	if want, have := initial[1].KeyID, header["kid"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// retired keys expire after the retention period
+	time.Sleep(10 * time.Millisecond)
+	km.JWKSet()
+	if km.PublicKey(initial[0].KeyID) != nil {
+		t.Errorf("expected retired key to expire")
+	}
+}
+
+func TestKeyManager_zeroValue(t *testing.T) {
+	generate := func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	// keys are generated on first use
+	km := &oasis.KeyManager{Generate: generate}
+	if want, have := oasis.AlgorithmES256, km.Algorithm(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := 2, len(km.JWKSet().Keys); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// only the added key, without next key
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	km = &oasis.KeyManager{}
+	if err := km.AddKey(edKey); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	set := km.JWKSet()
+	if want, have := 1, len(set.Keys); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if km.PublicKey(set.Keys[0].KeyID) == nil {
+		t.Errorf("expected public key of the added key")
+	}
+	if _, err := km.SignJWT("", map[string]string{"sub": "dummy-user"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// generation failure
+	km = &oasis.KeyManager{Generate: func() (crypto.Signer, error) {
+		return nil, errors.New("kms is down")
+	}}
+	if _, err := km.SignJWT("", map[string]string{"sub": "dummy-user"}); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if want, have := 0, len(km.JWKSet().Keys); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestKeyManager_concurrentRotation(t *testing.T) {
+	var generated int32
+	km, _ := oasis.NewKeyManager(func() (crypto.Signer, error) {
+		atomic.AddInt32(&generated, 1)
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}, 50*time.Millisecond, time.Minute)
+	time.Sleep(60 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			km.SignJWT("", map[string]string{"sub": "dummy-user"})
+		}()
+	}
+	wg.Wait()
+
+	// 2 keys on creation, and 1 next key on rotation
+	if want, have := int32(3), atomic.LoadInt32(&generated); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	km, _ := newTestKeyManager(t, 24*time.Hour, 48*time.Hour)
+	endpoint := oasis.NewJWKSEndpoint(km)
+
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/jwks", nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "public, max-age=86400", w.Header().Get("Cache-Control"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "application/jwk-set+json", w.Header().Get("Content-Type"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := 2, len(set.Keys); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	for _, key := range set.Keys {
+		if _, ok := key["d"]; ok {
+			t.Errorf("private key is published: %#v", key)
+		}
+		if want, have := "sig", key["use"]; want != have {
+			t.Errorf("expected %#v, got %#v", want, have)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("POST", "/jwks", nil))
+	if want, have := http.StatusMethodNotAllowed, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,160 @@
+package oasis
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// Logger is the interface for structured logging
+// of the endpoints and the ResponseEncoder.
+//
+// *slog.Logger implements Logger.
+type Logger interface {
+	LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// RequestIDHeader is the http header to read the
+// request ID from for logging.
+const RequestIDHeader = "X-Request-Id"
+
+// redacted replaces the value of secret parameters in logs.
+const redacted = "[REDACTED]"
+
+// loggedParams are the request and response parameters known to
+// carry no secret. The value of any other parameter (e.g. code,
+// state, tokens, passwords, and the handle or sealed state of a
+// staged authorization) is never logged as is.
+var loggedParams = map[string]bool{
+	"response_type":         true,
+	"response_mode":         true,
+	"client_id":             true,
+	"redirect_uri":          true,
+	"scope":                 true,
+	"grant_type":            true,
+	"code_challenge_method": true,
+	"prompt":                true,
+	"max_age":               true,
+	"acr_values":            true,
+	"display":               true,
+	"ui_locales":            true,
+	"error":                 true,
+	"error_description":     true,
+}
+
+// redactValues returns the encoded values with the value
+// of parameters other than loggedParams redacted.
+func redactValues(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+	safe := make(url.Values, len(values))
+	for key, vals := range values {
+		if loggedParams[key] {
+			safe[key] = vals
+			continue
+		}
+		safe[key] = make([]string, len(vals))
+		for i := range vals {
+			safe[key][i] = redacted
+		}
+	}
+	return safe.Encode()
+}
+
+// statusRecorder is an http.ResponseWriter
+// that records the response code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// responseOutcome describes the outcome of a Responder, and
+// the error it carries, if any, for logging.
+func responseOutcome(rspr Responder, status int) (outcome string, errCode string) {
+	switch rsp := rspr.(type) {
+	case *ErrorResponse:
+		return "error", string(rsp.Err.ErrorCode)
+	case *errorPageResponse:
+		return "error", string(rsp.err.ErrorCode)
+	case *RedirectResponse:
+		if code := rsp.Query.Get("error"); code != "" {
+			return "error", code
+		}
+		if code := rsp.Fragment.Get("error"); code != "" {
+			return "error", code
+		}
+	}
+	if status >= http.StatusBadRequest {
+		return "error", ""
+	}
+	return "ok", ""
+}
+
+// logLevel returns the log level for the response code.
+func logLevel(status int) slog.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return slog.LevelError
+	case status >= http.StatusBadRequest:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// encodeAndLog encodes the Responder with the encoder and, if
+// logger is set, logs the outcome with the given attributes.
+func encodeAndLog(
+	ctx context.Context,
+	logger Logger,
+	msg string,
+	w http.ResponseWriter,
+	r *http.Request,
+	encoder ResponseEncoder,
+	rspr Responder,
+	decodeErr error,
+	attrs ...slog.Attr,
+) {
+	if logger == nil {
+		encodeResponse(encoder, w, r, rspr)
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w}
+	encodeResponse(encoder, rec, r, rspr)
+
+	outcome, errCode := responseOutcome(rspr, rec.status)
+	attrs = append(attrs,
+		slog.String("request_id", r.Header.Get(RequestIDHeader)),
+		slog.String("path", r.URL.Path),
+		slog.String("query", redactValues(r.URL.Query())),
+		slog.String("outcome", outcome),
+		slog.Int("status", rec.status),
+	)
+	if errCode != "" {
+		attrs = append(attrs, slog.String("error", errCode))
+	}
+	if decodeErr != nil {
+		attrs = append(attrs, slog.String("decode_error", decodeErr.Error()))
+	}
+	level := logLevel(rec.status)
+	if outcome == "error" && level < slog.LevelWarn {
+		level = slog.LevelWarn // i.e. error redirected to client
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
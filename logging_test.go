@@ -0,0 +1,147 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestNewAuthorizeEndpoint_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	handler := oasis.NewAuthorizeEndpoint(
+		oasis.Context{
+			ClientStore: newTestClientStore(),
+			Logger:      logger,
+		},
+		oasis.NewAuthorizeDecoder("code"),
+		oasis.AuthorizeHandlerFunc(func(
+			ctx context.Context,
+			ar *oasis.AuthorizeRequest,
+			decodeErr error,
+		) oasis.Responder {
+			return oasis.NewAuthorizeErrorResponse(ctx, ar, oasis.NewError(oasis.ErrAccessDenied, ""))
+		}),
+		oasis.NewResponseEncoder(),
+	)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"web-client"},
+		"state":         {"secret-state"},
+		"oasis_handle":  {"secret-handle"},
+		"oasis_state":   {"secret-sealed"},
+		"otp":           {"secret-otp"},
+	}
+	r, _ := http.NewRequest("GET", "https://foobar.com/authorize?"+query.Encode(), nil)
+	r.Header.Set("X-Request-Id", "request-1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("unexpected error: %s\n%s", err, buf.String())
+	}
+	for key, expected := range map[string]interface{}{
+		"level":         "WARN",
+		"msg":           "authorize request",
+		"request_id":    "request-1",
+		"client_id":     "web-client",
+		"response_type": "code",
+		"stage":         float64(oasis.StageInitialize),
+		"outcome":       "error",
+		"error":         "access_denied",
+		"status":        float64(http.StatusTemporaryRedirect),
+	} {
+		if want, have := expected, record[key]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", key, want, have)
+		}
+	}
+	for _, secret := range []string{"secret-state", "secret-handle", "secret-sealed", "secret-otp"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("expected %s to be redacted, got %s", secret, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "response_type=code") {
+		t.Errorf("expected response_type to be logged, got %s", buf.String())
+	}
+}
+
+func TestNewTokenEndpoint_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	handler := oasis.NewTokenEndpoint(
+		oasis.Context{
+			TokenStorage: oasis.NewMemoryTokenStorage(),
+			Logger:       logger,
+		},
+		oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+		oasis.NewAuthorizationCodeHandler(),
+		oasis.NewResponseEncoder(),
+	)
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"secret-code"},
+		"client_id":     {"web-client"},
+		"client_secret": {"secret-password"},
+	}
+	r, _ := http.NewRequest("POST", "https://foobar.com/token?code=secret-code", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("unexpected error: %s\n%s", err, buf.String())
+	}
+	for key, expected := range map[string]interface{}{
+		"msg":        "token request",
+		"client_id":  "web-client",
+		"grant_type": "authorization_code",
+		"outcome":    "error",
+		"error":      "invalid_grant",
+	} {
+		if want, have := expected, record[key]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", key, want, have)
+		}
+	}
+	for _, secret := range []string{"secret-code", "secret-password"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("expected %s to be redacted, got %s", secret, buf.String())
+		}
+	}
+}
+
+func TestResponseEncoder_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := &oasis.DefaultResponseEncoder{
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+	}
+	encoder.EncodeResponse(httptest.NewRecorder(), errorResponder{
+		err: errors.New("connection reset"),
+	})
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("unexpected error: %s\n%s", err, buf.String())
+	}
+	for key, expected := range map[string]interface{}{
+		"level": "ERROR",
+		"msg":   "responder failed",
+		"error": "connection reset",
+	} {
+		if want, have := expected, record[key]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", key, want, have)
+		}
+	}
+}
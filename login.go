@@ -0,0 +1,446 @@
+package oasis
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Names of the login form fields.
+const (
+	// UsernameParam is the name of the username field.
+	UsernameParam = "username"
+
+	// PasswordParam is the name of the password field.
+	PasswordParam = "password"
+
+	// CSRFTokenParam is the name of the cookie that carries the
+	// anti-CSRF token, and of the hidden form field that carries
+	// the token of the form derived from it (see LoginHandler).
+	CSRFTokenParam = "oasis_csrf"
+)
+
+// ErrInvalidCredentials is returned by UserAuthenticator if the
+// username or password is wrong. Other errors are treated as
+// server errors.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// UserAuthenticator verifies the credentials of a resource owner.
+type UserAuthenticator interface {
+
+	// AuthenticateUser returns the user id of the resource owner
+	// if the credentials are correct, or ErrInvalidCredentials
+	// if not.
+	AuthenticateUser(ctx context.Context, username, password string) (userID string, err error)
+}
+
+// UserAuthenticatorFunc is the function type of UserAuthenticator.
+type UserAuthenticatorFunc func(ctx context.Context, username, password string) (userID string, err error)
+
+// AuthenticateUser implements UserAuthenticator
+func (f UserAuthenticatorFunc) AuthenticateUser(ctx context.Context, username, password string) (string, error) {
+	return f(ctx, username, password)
+}
+
+// LoginPageData is the data to render the login page template.
+type LoginPageData struct {
+
+	// Action is the URL the form is posted to.
+	Action string
+
+	// Hidden are the hidden form fields (name to value) that
+	// must be posted back with the form.
+	Hidden map[string]string
+
+	// Username is the username of the previous attempt, if any.
+	Username string
+
+	// Error is the user understandable message about the
+	// previous attempt, if any.
+	Error string
+
+	// ClientID is the id of the client requesting authorization.
+	ClientID string
+
+	// Scope is the scope requested by the client.
+	Scope string
+
+	// Branding of the login page.
+	Branding Branding
+}
+
+// DefaultLoginTemplate is the template used to render the
+// login page by LoginHandler if no template is set.
+var DefaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in{{ with .Branding.Name }} - {{ . }}{{ end }}</title>
+{{- with .Branding.StylesheetURL }}
+<link rel="stylesheet" href="{{ . }}">
+{{- end }}
+</head>
+<body>
+{{- with .Branding.LogoURL }}
+<img src="{{ . }}" alt="{{ $.Branding.Name }}">
+{{- end }}
+<h1>Sign in</h1>
+{{- with .ClientID }}
+<p>to continue to {{ . }}</p>
+{{- end }}
+{{- with .Error }}
+<p role="alert"><strong>{{ . }}</strong></p>
+{{- end }}
+<form method="post" action="{{ .Action }}">
+{{- range $name, $value := .Hidden }}
+<input type="hidden" name="{{ $name }}" value="{{ $value }}">
+{{- end }}
+<p><label>Username <input type="text" name="username" value="{{ .Username }}" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+{{- with .Branding.SupportURL }}
+<p><a href="{{ . }}">Get help</a></p>
+{{- end }}
+</body>
+</html>`))
+
+// formPageHeader are the headers set on every page with a form.
+// Like errorPageHeader, except the form may be posted back to
+// the server itself.
+var formPageHeader = map[string]string{
+	"Cache-Control":           "no-store",
+	"Pragma":                  "no-cache",
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "no-referrer",
+	"Content-Security-Policy": "default-src 'none'; img-src https: data:; style-src https:; frame-ancestors 'none'; base-uri 'none'; form-action 'self'",
+}
+
+// LoginHandler is an AuthorizeHandler of the login stage.
+//
+// It shows a login form to the user, and verifies the posted
+// credentials with Authenticator. The username is prefilled with
+// the login_hint, if any. On success, ar.UserID and ar.AuthTime
+// are set,
+// the request is moved to stage Next, and the user-agent is
+// redirected back to the authorization endpoint.
+//
+// The AuthorizeRequest is carried between requests by the
+// PendingAuthorizationStore of the Context, or by its StateSealer
+// if there is no store. One of them must be set. Sealed requests
+// need the ReplayCache of the Context to get only one response.
+//
+// The form is protected from CSRF by a random token, which is set
+// as a cookie and must be posted back in the form (i.e. double
+// submit cookie). The token in the form is bound to the carried
+// AuthorizeRequest, as an HMAC of its PendingHandle or SealedStateID
+// keyed by the cookie, so the form is refused if the carrier cookie
+// has been replaced since, e.g. by another authorization started
+// in the same user-agent.
+type LoginHandler struct {
+
+	// Authenticator verifies the posted credentials.
+	Authenticator UserAuthenticator
+
+	// Next is the stage the request is moved to after login.
+	Next AuthorizeStage
+
+	// Template renders the login page with *LoginPageData.
+	// DefaultLoginTemplate is used if not set.
+	Template *template.Template
+
+	// Branding is passed to Template with every login page.
+	Branding Branding
+}
+
+// NewLoginHandler returns a *LoginHandler that authenticates
+// with the authenticator, then moves the request to stage next.
+func NewLoginHandler(authenticator UserAuthenticator, next AuthorizeStage) *LoginHandler {
+	return &LoginHandler{
+		Authenticator: authenticator,
+		Next:          next,
+	}
+}
+
+// HandleAuthorizeRequest implements AuthorizeHandler
+func (lh *LoginHandler) HandleAuthorizeRequest(ctx context.Context, ar *AuthorizeRequest, decodeErr error) Responder {
+	if decodeErr != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, decodeErr)
+	}
+	actx, err := formStageContext(ctx, ar)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	r := ar.HTTPRequest
+
+	// the login form is an interaction, which is not
+	// allowed by prompt=none (OpenID Connect Core 1.0
+	// section 3.1.2.1)
+	if ar.HasPrompt(PromptNone) {
+		return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrLoginRequired, "user is not authenticated"))
+	}
+
+	if r.Method != http.MethodPost {
+		return lh.loginPage(ctx, actx, ar, ar.LoginHint, "")
+	}
+
+	username := r.PostFormValue(UsernameParam)
+	if !validCSRFToken(ar) {
+		return lh.loginPage(ctx, actx, ar, username, "Your session has expired. Please try again.")
+	}
+	password := r.PostFormValue(PasswordParam)
+	if username == "" || password == "" {
+		return lh.loginPage(ctx, actx, ar, username, "Please enter your username and password.")
+	}
+
+	userID, err := lh.Authenticator.AuthenticateUser(ctx, username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return lh.loginPage(ctx, actx, ar, username, "Invalid username or password.")
+	} else if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+
+	ar.UserID = userID
+	authTime := time.Now()
+	ar.AuthTime = &authTime
+	return proceedToStage(ctx, actx, ar, lh.Next)
+}
+
+// loginPage renders the login form with the AuthorizeRequest
+// carried in the cookie.
+func (lh *LoginHandler) loginPage(ctx context.Context, actx *Context, ar *AuthorizeRequest, username, message string) Responder {
+	carrier, err := carryRequest(ctx, actx, ar)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	hidden := make(map[string]string)
+
+	tmpl := lh.Template
+	if tmpl == nil {
+		tmpl = DefaultLoginTemplate
+	}
+	rsp, err := renderFormPage(ar, tmpl, &LoginPageData{
+		Action:   ar.HTTPRequest.URL.Path,
+		Hidden:   hidden,
+		Username: username,
+		Error:    message,
+		ClientID: ar.ClientID,
+		Scope:    ar.Scope,
+		Branding: lh.Branding,
+	}, hidden, carrier)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	return rsp
+}
+
+// formStageContext returns the *Context in ctx, if it has the
+// PendingAuthorizationStore or StateSealer to carry the
+// AuthorizeRequest between the form and its submission.
+func formStageContext(ctx context.Context, ar *AuthorizeRequest) (*Context, error) {
+	actx := GetContext(ctx)
+	if actx == nil || (actx.PendingAuthorizationStore == nil && actx.StateSealer == nil) {
+		return nil, fmt.Errorf("pending authorization store or state sealer is required but not set in context")
+	}
+	if ar.HTTPRequest == nil {
+		return nil, fmt.Errorf("http request is required but not set")
+	}
+	return actx, nil
+}
+
+// carryRequest returns the cookie to carry the AuthorizeRequest to
+// the next request. The request is saved in the PendingAuthorizationStore,
+// if it is not yet, or sealed by the StateSealer.
+func carryRequest(ctx context.Context, actx *Context, ar *AuthorizeRequest) (*http.Cookie, error) {
+	if actx.PendingAuthorizationStore != nil {
+		if ar.PendingHandle == "" {
+			handle, err := actx.CreatePending(ctx, ar)
+			if err != nil {
+				return nil, err
+			}
+			ar.PendingHandle = handle
+		}
+		return carrierCookie(ar.HTTPRequest, PendingHandleParam, ar.PendingHandle), nil
+	}
+
+	sealed, err := actx.StateSealer.Seal(ar)
+	if err != nil {
+		return nil, err
+	}
+	return carrierCookie(ar.HTTPRequest, SealedStateParam, sealed), nil
+}
+
+// carrierCookie returns the cookie of the authorization endpoint at r
+// that carries the AuthorizeRequest between stages. An empty value
+// returns the cookie that removes it.
+//
+// The request is never carried in the URL or the form, so that a
+// victim cannot be made to complete the authorization of another
+// user-agent (login fixation): the cookie is HttpOnly, and being
+// SameSite, it is not sent with cross-site posts either.
+func carrierCookie(r *http.Request, name, value string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     r.URL.Path,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// clearCarrier adds to header the Set-Cookie that removes the
+// carrier cookie sent with r, if any, once the authorization ends.
+func clearCarrier(r *http.Request, header http.Header) {
+	if r == nil || header == nil {
+		return
+	}
+	for _, name := range []string{PendingHandleParam, SealedStateParam} {
+		if _, err := r.Cookie(name); err == nil {
+			header.Add("Set-Cookie", carrierCookie(r, name, "").String())
+		}
+	}
+}
+
+// proceedToStage moves the AuthorizeRequest to stage next, and
+// redirects the user-agent back to the authorization endpoint with
+// the request carried in the cookie.
+func proceedToStage(ctx context.Context, actx *Context, ar *AuthorizeRequest, next AuthorizeStage) Responder {
+	carrier, rspr := advanceStage(ctx, actx, ar, next)
+	if rspr != nil {
+		return rspr
+	}
+
+	// 303 so the user-agent would not post the form again
+	header := make(http.Header)
+	header.Set("Location", ar.HTTPRequest.URL.Path)
+	header.Set("Cache-Control", "no-store")
+	header.Add("Set-Cookie", carrier.String())
+	return &ResponseCache{
+		Code:        http.StatusSeeOther,
+		HeaderCache: header,
+	}
+}
+
+// saveRequest saves the changes to the AuthorizeRequest, without
+// moving it to another stage, and returns the cookie to carry it,
+// or else the Responder of the error.
+func saveRequest(ctx context.Context, actx *Context, ar *AuthorizeRequest) (*http.Cookie, Responder) {
+	if actx.PendingAuthorizationStore != nil && ar.PendingHandle == "" {
+		carrier, err := carryRequest(ctx, actx, ar)
+		if err != nil {
+			return nil, NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+		return carrier, nil
+	}
+	return advanceStage(ctx, actx, ar, ar.Stage)
+}
+
+// advanceStage moves the AuthorizeRequest to stage next, and returns
+// the cookie to carry it, or else the Responder of the error.
+func advanceStage(ctx context.Context, actx *Context, ar *AuthorizeRequest, next AuthorizeStage) (*http.Cookie, Responder) {
+	if err := CheckStageTransition(ctx, ar.Stage, next); err != nil {
+		return nil, &stageErrorResponse{err: err.(*StageError)}
+	}
+	if actx.PendingAuthorizationStore != nil {
+		err := AdvancePending(ctx, ar, next)
+		switch err {
+		case nil:
+		case ErrPendingNotFound:
+			return nil, NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has expired"))
+		case ErrPendingConflict:
+			return nil, NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has been changed by another request"))
+		default:
+			return nil, NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+		return carrierCookie(ar.HTTPRequest, PendingHandleParam, ar.PendingHandle), nil
+	}
+
+	ar.Stage = next
+	sealed, err := actx.StateSealer.Seal(ar)
+	if err != nil {
+		return nil, NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	return carrierCookie(ar.HTTPRequest, SealedStateParam, sealed), nil
+}
+
+// renderFormPage renders the form page of ar with data, and sets the
+// carrier cookie. A CSRF token is set as a cookie if the request does
+// not carry one already, and the token of the form is added to the
+// hidden fields before rendering.
+func renderFormPage(ar *AuthorizeRequest, tmpl *template.Template, data interface{}, hidden map[string]string, carrier *http.Cookie) (*ResponseCache, error) {
+	r := ar.HTTPRequest
+	header := make(http.Header)
+	header.Add("Set-Cookie", carrier.String())
+	token := ""
+	if cookie, err := r.Cookie(CSRFTokenParam); err == nil && cookie.Value != "" {
+		token = cookie.Value
+	} else {
+		if token, err = newHandle(); err != nil {
+			return nil, err
+		}
+		header.Add("Set-Cookie", (&http.Cookie{
+			Name:     CSRFTokenParam,
+			Value:    token,
+			Path:     r.URL.Path,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		}).String())
+	}
+	hidden[CSRFTokenParam] = formToken(token, ar)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("unable to render page. %s", err.Error())
+	}
+	for key, value := range formPageHeader {
+		header.Set(key, value)
+	}
+	header.Set("Content-Type", "text/html;charset=utf-8")
+	return &ResponseCache{
+		Code:        http.StatusOK,
+		HeaderCache: header,
+		Body:        &buf,
+	}, nil
+}
+
+// formToken returns the token of the form for ar, i.e. the HMAC
+// of the PendingHandle or SealedStateID of the carried request
+// keyed by the CSRF token.
+func formToken(token string, ar *AuthorizeRequest) string {
+	id := ar.PendingHandle
+	if id == "" {
+		id = ar.SealedStateID
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken reports whether the posted token matches the
+// token of the form for ar, with the CSRF token in the cookie.
+func validCSRFToken(ar *AuthorizeRequest) bool {
+	if ar.PendingHandle == "" && ar.SealedStateID == "" {
+		return false
+	}
+	cookie, err := ar.HTTPRequest.Cookie(CSRFTokenParam)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	posted := ar.HTTPRequest.PostFormValue(CSRFTokenParam)
+	return subtle.ConstantTimeCompare([]byte(formToken(cookie.Value, ar)), []byte(posted)) == 1
+}
@@ -0,0 +1,237 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+var testAuthenticator = oasis.UserAuthenticatorFunc(func(ctx context.Context, username, password string) (string, error) {
+	switch {
+	case username == "dummy" && password == "secret":
+		return "dummy-user", nil
+	case username == "broken":
+		return "", errors.New("database is down")
+	}
+	return "", oasis.ErrInvalidCredentials
+})
+
+// hiddenFields returns the hidden form fields in the html page.
+func hiddenFields(body string) url.Values {
+	form := make(url.Values)
+	re := regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)">`)
+	for _, match := range re.FindAllStringSubmatch(body, -1) {
+		form.Set(match[1], match[2])
+	}
+	return form
+}
+
+// responseCookie returns the named cookie set by the response.
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func newLoginEndpoint(actx oasis.Context, users *[]string) http.Handler {
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageInitialize, oasis.NewLoginHandler(testAuthenticator, oasis.StageToAuthorize))
+	mux.AddFunc(oasis.StageToAuthorize, func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+		*users = append(*users, ar.UserID)
+		return &oasis.ResponseCache{Code: http.StatusOK}
+	})
+	mux.Allow(oasis.StageInitialize, oasis.StageToAuthorize)
+	mux.Require(oasis.StageToAuthorize, oasis.RequireUser)
+	actx.ClientStore = newTestClientStore()
+	return oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+}
+
+func TestLoginHandler(t *testing.T) {
+	sealer, _ := oasis.NewAESStateSealer(time.Minute, bytes.Repeat([]byte("k"), 32))
+	tests := map[string]struct {
+		actx    oasis.Context
+		carrier string
+	}{
+		"pending": {
+			actx:    oasis.Context{PendingAuthorizationStore: oasis.NewMemoryPendingAuthorizationStore(time.Minute)},
+			carrier: oasis.PendingHandleParam,
+		},
+		"sealed": {
+			actx:    oasis.Context{StateSealer: sealer},
+			carrier: oasis.SealedStateParam,
+		},
+	}
+
+	for name, test := range tests {
+		actx, carrierName := test.actx, test.carrier
+		t.Run(name, func(t *testing.T) {
+			var users []string
+			endpoint := newLoginEndpoint(actx, &users)
+
+			// login page
+			w := httptest.NewRecorder()
+			endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=code&client_id=web-client", nil))
+			if want, have := http.StatusOK, w.Code; want != have {
+				t.Fatalf("expected %#v, got %#v", want, have)
+			}
+			if want, have := "DENY", w.Header().Get("X-Frame-Options"); want != have {
+				t.Errorf("expected %#v, got %#v", want, have)
+			}
+			csrf := responseCookie(w, oasis.CSRFTokenParam)
+			if csrf == nil || !csrf.HttpOnly {
+				t.Fatalf("expected an http only CSRF cookie, got %#v", w.Result().Cookies())
+			}
+			carrier := responseCookie(w, carrierName)
+			if carrier == nil || !carrier.HttpOnly || carrier.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("expected an http only, same site carrier cookie, got %#v", w.Result().Cookies())
+			}
+			form := hiddenFields(w.Body.String())
+			if token := form.Get(oasis.CSRFTokenParam); token == "" || token == csrf.Value {
+				t.Errorf("expected a form token bound to the request, got %#v", token)
+			}
+			if _, ok := form[carrierName]; ok {
+				t.Errorf("unexpected carrier in the hidden fields %#v", form)
+			}
+
+			post := func(form url.Values, withCookie bool) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				r.Header.Set("Accept", "text/html")
+				r.AddCookie(carrier)
+				if withCookie {
+					r.AddCookie(csrf)
+				}
+				w := httptest.NewRecorder()
+				endpoint.ServeHTTP(w, r)
+				return w
+			}
+
+			// wrong password
+			form.Set(oasis.UsernameParam, "dummy")
+			form.Set(oasis.PasswordParam, "wrong")
+			w = post(form, true)
+			if !strings.Contains(w.Body.String(), "Invalid username or password.") {
+				t.Errorf("expected login error, got %s", w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), `value="dummy"`) {
+				t.Errorf("expected username to be kept, got %s", w.Body.String())
+			}
+
+			// without the CSRF cookie
+			form.Set(oasis.PasswordParam, "secret")
+			w = post(form, false)
+			if !strings.Contains(w.Body.String(), "Your session has expired.") {
+				t.Errorf("expected CSRF error, got %s", w.Body.String())
+			}
+
+			// not accepted for another authorization
+			// started in the same user-agent
+			other := httptest.NewRequest("GET", "/authorize?response_type=code&client_id=web-client&state=other", nil)
+			other.AddCookie(csrf)
+			w = httptest.NewRecorder()
+			endpoint.ServeHTTP(w, other)
+			first := carrier
+			if carrier = responseCookie(w, carrierName); carrier == nil {
+				t.Fatalf("expected carrier cookie, got %#v", w.Result().Cookies())
+			}
+			w = post(form, true)
+			if !strings.Contains(w.Body.String(), "Your session has expired.") {
+				t.Errorf("expected CSRF error, got %s", w.Body.String())
+			}
+			if len(users) != 0 {
+				t.Errorf("expected no user to be authorized, got %#v", users)
+			}
+			carrier = first
+
+			// authenticator failure
+			form.Set(oasis.UsernameParam, "broken")
+			w = post(form, true)
+			if want, have := http.StatusTemporaryRedirect, w.Code; want != have {
+				t.Errorf("expected %#v, got %#v", want, have)
+			}
+			if location := w.Header().Get("Location"); !strings.Contains(location, "error=server_error") {
+				t.Errorf("expected server_error redirection, got %#v", location)
+			}
+			if len(users) != 0 {
+				t.Errorf("expected no user to be authorized, got %#v", users)
+			}
+
+			// success
+			form.Set(oasis.UsernameParam, "dummy")
+			w = post(form, true)
+			if want, have := http.StatusSeeOther, w.Code; want != have {
+				t.Fatalf("expected %#v, got %#v", want, have)
+			}
+			location := w.Header().Get("Location")
+			if want, have := "/authorize", location; want != have {
+				t.Fatalf("expected %#v, got %#v", want, have)
+			}
+			next := responseCookie(w, carrierName)
+			if next == nil {
+				t.Fatalf("expected carrier cookie, got %#v", w.Result().Cookies())
+			}
+
+			// the carrier in the query is not accepted
+			w = httptest.NewRecorder()
+			endpoint.ServeHTTP(w, httptest.NewRequest("GET", location+"?"+carrierName+"="+url.QueryEscape(next.Value), nil))
+			if len(users) != 0 {
+				t.Errorf("expected no user to be authorized, got %#v", users)
+			}
+
+			r := httptest.NewRequest("GET", location, nil)
+			r.AddCookie(next)
+			w = httptest.NewRecorder()
+			endpoint.ServeHTTP(w, r)
+			if want, have := http.StatusOK, w.Code; want != have {
+				t.Errorf("expected %#v, got %#v", want, have)
+			}
+			if want, have := "dummy-user", strings.Join(users, ","); want != have {
+				t.Errorf("expected %#v, got %#v", want, have)
+			}
+		})
+	}
+}
+
+func TestLoginHandler_noCarrier(t *testing.T) {
+	var users []string
+	endpoint := newLoginEndpoint(oasis.Context{}, &users)
+	r := httptest.NewRequest("GET", "/authorize?response_type=code&client_id=web-client", nil)
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "https://web.foobar.com/cb?error=server_error") {
+		t.Errorf("expected server_error redirection, got %#v", location)
+	}
+}
+
+func TestLoginHandler_prompt(t *testing.T) {
+	var users []string
+	endpoint := newLoginEndpoint(oasis.Context{
+		PendingAuthorizationStore: oasis.NewMemoryPendingAuthorizationStore(time.Minute),
+	}, &users)
+
+	// no interaction allowed
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=code&client_id=web-client&scope=openid&prompt=none", nil))
+	if location := w.Header().Get("Location"); !strings.HasPrefix(location, "https://web.foobar.com/cb?error=login_required") {
+		t.Errorf("expected login_required redirection, got %#v", location)
+	}
+
+	// prefilled with login_hint
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=code&client_id=web-client&scope=openid&login_hint=dummy", nil))
+	if !strings.Contains(w.Body.String(), `name="username" value="dummy"`) {
+		t.Errorf("expected username to be prefilled, got %s", w.Body.String())
+	}
+}
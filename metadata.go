@@ -0,0 +1,197 @@
+package oasis
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Well-known paths of the metadata document. RFC8414 section 3
+// defines the former, OpenID Connect Discovery 1.0 section 4
+// the latter. Both serve the same document.
+const (
+	WellKnownOAuthAuthorizationServer = "/.well-known/oauth-authorization-server"
+	WellKnownOpenIDConfiguration      = "/.well-known/openid-configuration"
+)
+
+// Client authentication methods at the token endpoint, as
+// registered by RFC7591 section 2.
+const (
+	TokenEndpointAuthMethodNone              = "none"
+	TokenEndpointAuthMethodClientSecretBasic = "client_secret_basic"
+	TokenEndpointAuthMethodClientSecretPost  = "client_secret_post"
+)
+
+// Metadata is the Authorization Server Metadata as described
+// in RFC8414 section 2, with the additional fields of OpenID
+// Provider Metadata (OpenID Connect Discovery 1.0 section 3).
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	UserInfoSigningAlgValuesSupported []string `json:"userinfo_signing_alg_values_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+	PromptValuesSupported             []string `json:"prompt_values_supported,omitempty"`
+}
+
+// MetadataConfig is the configuration the Metadata is
+// generated from. The endpoints are absolute URLs.
+type MetadataConfig struct {
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	UserInfoEndpoint      string
+	JWKSURI               string
+
+	// AuthorizeDecoder and TokenDecoder, if they are the default
+	// implementations, supply the allowed response_type and
+	// grant_type values.
+	AuthorizeDecoder AuthorizeDecoder
+	TokenDecoder     TokenDecoder
+
+	// Scopes are the scope values supported. If not set and
+	// Context.IDTokenSigner is set, the openid scope and the
+	// scopes of ScopeClaims are listed.
+	Scopes []string
+
+	// TokenEndpointAuthMethods are the client authentication
+	// methods supported at the token endpoint. If not set, they
+	// are client_secret_basic, client_secret_post and none if
+	// Context.ClientStore is set, or else only none, as clients
+	// are not authenticated.
+	TokenEndpointAuthMethods []string
+
+	// CodeChallengeMethods are the PKCE code challenge methods
+	// supported. If not set, both S256 and plain are listed, as
+	// the zero PKCEPolicy accepts. It should be set to S256 only
+	// if all clients have PKCEPolicy.ForbidPlain.
+	CodeChallengeMethods []string
+}
+
+// NewMetadata generates the Metadata of the authorization server
+// from the same Context and decoders the endpoints are built with,
+// so the document never goes stale.
+//
+// The issuer is Context.Issuer. The signing algorithms are those
+// of Context.IDTokenSigner and Context.UserInfoSigner, and the
+// OpenID Provider fields are only set if IDTokenSigner is set.
+func NewMetadata(actx Context, config MetadataConfig) *Metadata {
+	metadata := &Metadata{
+		Issuer:                 actx.Issuer,
+		AuthorizationEndpoint:  config.AuthorizationEndpoint,
+		TokenEndpoint:          config.TokenEndpoint,
+		UserInfoEndpoint:       config.UserInfoEndpoint,
+		JWKSURI:                config.JWKSURI,
+		ScopesSupported:        config.Scopes,
+		ResponseTypesSupported: []string{},
+
+		TokenEndpointAuthMethodsSupported: config.TokenEndpointAuthMethods,
+	}
+	if metadata.TokenEndpointAuthMethodsSupported == nil {
+		metadata.TokenEndpointAuthMethodsSupported = []string{TokenEndpointAuthMethodNone}
+		if actx.ClientStore != nil {
+			metadata.TokenEndpointAuthMethodsSupported = []string{
+				TokenEndpointAuthMethodClientSecretBasic,
+				TokenEndpointAuthMethodClientSecretPost,
+				TokenEndpointAuthMethodNone,
+			}
+		}
+	}
+
+	if decoder, ok := config.AuthorizeDecoder.(interface{ ResponseTypes() []string }); ok {
+		for _, responseType := range decoder.ResponseTypes() {
+			if responseType != "token" || !actx.DisableImplicitGrant {
+				metadata.ResponseTypesSupported = append(metadata.ResponseTypesSupported, responseType)
+			}
+		}
+	}
+	if containsString(metadata.ResponseTypesSupported, "code") {
+		metadata.CodeChallengeMethodsSupported = config.CodeChallengeMethods
+		if metadata.CodeChallengeMethodsSupported == nil {
+			metadata.CodeChallengeMethodsSupported = []string{
+				CodeChallengeMethodS256,
+				CodeChallengeMethodPlain,
+			}
+		}
+	}
+	if decoder, ok := config.TokenDecoder.(interface{ GrantTypes() []string }); ok {
+		metadata.GrantTypesSupported = decoder.GrantTypes()
+	}
+
+	// the implicit grant has no grant_type at the token endpoint,
+	// but is listed if enabled, as RFC8414 section 2 defaults to
+	// "authorization_code" and "implicit" if omitted
+	if metadata.GrantTypesSupported == nil && containsString(metadata.ResponseTypesSupported, "code") {
+		metadata.GrantTypesSupported = []string{GrantTypeAuthorizationCode}
+	}
+	if containsString(metadata.ResponseTypesSupported, "token") {
+		metadata.GrantTypesSupported = append(metadata.GrantTypesSupported, GrantTypeImplicit)
+	}
+
+	if actx.IDTokenSigner != nil {
+		if metadata.ScopesSupported == nil {
+			scopes := make(map[string]bool)
+			for scope := range ScopeClaims {
+				scopes[scope] = true
+			}
+			metadata.ScopesSupported = append([]string{ScopeOpenID}, sortedKeys(scopes)...)
+		}
+		metadata.SubjectTypesSupported = []string{"public"}
+		metadata.IDTokenSigningAlgValuesSupported = []string{actx.IDTokenSigner.Algorithm()}
+		metadata.ClaimsSupported = []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce"}
+		for _, scope := range metadata.ScopesSupported {
+			metadata.ClaimsSupported = append(metadata.ClaimsSupported, ScopeClaims[scope]...)
+		}
+		// select_account is accepted, but no handler implements it
+		metadata.PromptValuesSupported = []string{PromptNone, PromptLogin, PromptConsent}
+	}
+	if actx.UserInfoSigner != nil {
+		metadata.UserInfoSigningAlgValuesSupported = []string{actx.UserInfoSigner.Algorithm()}
+	}
+	return metadata
+}
+
+// NewMetadataEndpoint returns an http.Handler to serve the metadata
+// document as JSON. It should be routed to both of the well-known
+// paths (see WellKnownOAuthAuthorizationServer). The document is
+// marshaled once and may be cached and read cross-origin.
+func NewMetadataEndpoint(metadata *Metadata) http.Handler {
+	content, err := json.Marshal(metadata)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, NewError(ErrServerError, "unable to marshal metadata"))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	})
+}
+
+// sortedKeys returns the keys of the set, sorted.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
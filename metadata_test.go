@@ -0,0 +1,141 @@
+package oasis_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestNewMetadata(t *testing.T) {
+	signer, _ := oasis.NewSigningKey("dummy-key", bytes.Repeat([]byte("k"), 32))
+	metadata := oasis.NewMetadata(oasis.Context{
+		Issuer:        "https://foobar.com",
+		IDTokenSigner: signer,
+	}, oasis.MetadataConfig{
+		AuthorizationEndpoint: "https://foobar.com/authorize",
+		TokenEndpoint:         "https://foobar.com/token",
+		UserInfoEndpoint:      "https://foobar.com/userinfo",
+		JWKSURI:               "https://foobar.com/jwks",
+		AuthorizeDecoder:      oasis.NewAuthorizeDecoder("token", "code"),
+		TokenDecoder:          oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+	})
+
+	tests := []struct {
+		name     string
+		expected interface{}
+		result   interface{}
+	}{
+		{"issuer", "https://foobar.com", metadata.Issuer},
+		{"authorization_endpoint", "https://foobar.com/authorize", metadata.AuthorizationEndpoint},
+		{"response_types_supported", []string{"code", "token"}, metadata.ResponseTypesSupported},
+		{"grant_types_supported", []string{oasis.GrantTypeAuthorizationCode, oasis.GrantTypeImplicit}, metadata.GrantTypesSupported},
+		{"code_challenge_methods_supported", []string{"S256", "plain"}, metadata.CodeChallengeMethodsSupported},
+		{"scopes_supported", []string{"openid", "address", "email", "phone", "profile"}, metadata.ScopesSupported},
+		{"id_token_signing_alg_values_supported", []string{"HS256"}, metadata.IDTokenSigningAlgValuesSupported},
+		{"subject_types_supported", []string{"public"}, metadata.SubjectTypesSupported},
+		{"prompt_values_supported", []string{"none", "login", "consent"}, metadata.PromptValuesSupported},
+		{"userinfo_signing_alg_values_supported", []string(nil), metadata.UserInfoSigningAlgValuesSupported},
+		{"token_endpoint_auth_methods_supported", []string{"none"}, metadata.TokenEndpointAuthMethodsSupported},
+	}
+	for _, test := range tests {
+		if want, have := test.expected, test.result; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, want, have)
+		}
+	}
+}
+
+func TestNewMetadata_OAuth(t *testing.T) {
+	metadata := oasis.NewMetadata(oasis.Context{Issuer: "https://foobar.com"}, oasis.MetadataConfig{
+		Scopes: []string{"read", "write"},
+	})
+	if want, have := []string{"read", "write"}, metadata.ScopesSupported; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for name, value := range map[string][]string{
+		"code_challenge_methods_supported":      metadata.CodeChallengeMethodsSupported,
+		"id_token_signing_alg_values_supported": metadata.IDTokenSigningAlgValuesSupported,
+		"claims_supported":                      metadata.ClaimsSupported,
+	} {
+		if value != nil {
+			t.Errorf("%s: expected nil, got %#v", name, value)
+		}
+	}
+}
+
+func TestNewMetadata_methods(t *testing.T) {
+	config := oasis.MetadataConfig{AuthorizeDecoder: oasis.NewAuthorizeDecoder("code")}
+	tests := []struct {
+		desc                string
+		actx                oasis.Context
+		config              oasis.MetadataConfig
+		expectedAuthMethods []string
+		expectedPKCEMethods []string
+	}{
+		{
+			desc:                "clients authenticated by the client store",
+			actx:                oasis.Context{ClientStore: newTestClientStore()},
+			config:              config,
+			expectedAuthMethods: []string{"client_secret_basic", "client_secret_post", "none"},
+			expectedPKCEMethods: []string{"S256", "plain"},
+		},
+		{
+			desc: "configured",
+			actx: oasis.Context{ClientStore: newTestClientStore()},
+			config: oasis.MetadataConfig{
+				AuthorizeDecoder:         oasis.NewAuthorizeDecoder("code"),
+				TokenEndpointAuthMethods: []string{oasis.TokenEndpointAuthMethodClientSecretBasic},
+				CodeChallengeMethods:     []string{oasis.CodeChallengeMethodS256},
+			},
+			expectedAuthMethods: []string{"client_secret_basic"},
+			expectedPKCEMethods: []string{"S256"},
+		},
+	}
+	for _, test := range tests {
+		metadata := oasis.NewMetadata(test.actx, test.config)
+		if want, have := test.expectedAuthMethods, metadata.TokenEndpointAuthMethodsSupported; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := test.expectedPKCEMethods, metadata.CodeChallengeMethodsSupported; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestMetadataEndpoint(t *testing.T) {
+	endpoint := oasis.NewMetadataEndpoint(oasis.NewMetadata(oasis.Context{Issuer: "https://foobar.com"}, oasis.MetadataConfig{
+		AuthorizeDecoder: oasis.NewAuthorizeDecoder("code"),
+	}))
+
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", oasis.WellKnownOAuthAuthorizationServer, nil))
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "*", w.Header().Get("Access-Control-Allow-Origin"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "https://foobar.com", body["issuer"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := []interface{}{"code"}, body["response_types_supported"]; !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if _, ok := body["jwks_uri"]; ok {
+		t.Errorf("unexpected jwks_uri")
+	}
+
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("POST", oasis.WellKnownOpenIDConfiguration, nil))
+	if want, have := http.StatusMethodNotAllowed, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,127 @@
+// Package oasistest provides utilities for testing
+// implementations of the oasis interfaces.
+package oasistest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+// TestPendingAuthorizationStore runs the conformance test of
+// oasis.PendingAuthorizationStore against the store.
+//
+// The store should be empty, and its handles should not
+// expire during the test.
+func TestPendingAuthorizationStore(t *testing.T, store oasis.PendingAuthorizationStore) {
+	ctx := context.Background()
+	ar := &oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "dummy-client",
+		RedirectURI:  "https://client.foobar.com/cb",
+		Scope:        "read",
+		State:        "dummy-state",
+		Stage:        oasis.StageInitialize,
+	}
+
+	// create
+	handle, err := store.CreatePending(ctx, ar)
+	if err != nil {
+		t.Fatalf("CreatePending: unexpected error: %s", err)
+	}
+	if handle == "" {
+		t.Fatalf("CreatePending: expected handle, got nothing")
+	}
+	other, err := store.CreatePending(ctx, ar)
+	if err != nil {
+		t.Fatalf("CreatePending: unexpected error: %s", err)
+	}
+	if handle == other {
+		t.Errorf("CreatePending: expected unique handles, got %#v twice", handle)
+	}
+
+	// get
+	found, err := store.GetPending(ctx, handle)
+	if err != nil {
+		t.Fatalf("GetPending: unexpected error: %s", err)
+	}
+	if want, have := ar.ClientID, found.ClientID; want != have {
+		t.Errorf("GetPending: expected %#v, got %#v", want, have)
+	}
+	if want, have := ar.State, found.State; want != have {
+		t.Errorf("GetPending: expected %#v, got %#v", want, have)
+	}
+	if want, have := ar.Stage, found.Stage; want != have {
+		t.Errorf("GetPending: expected %#v, got %#v", want, have)
+	}
+	if _, err := store.GetPending(ctx, "unknown-handle"); err != oasis.ErrPendingNotFound {
+		t.Errorf("GetPending: expected ErrPendingNotFound, got %#v", err)
+	}
+
+	// update from the current stage
+	next := *found
+	next.Stage = oasis.StageToAuthorize
+	next.UserID = "dummy-user"
+	if err := store.UpdatePending(ctx, handle, oasis.StageInitialize, &next); err != nil {
+		t.Fatalf("UpdatePending: unexpected error: %s", err)
+	}
+	found, _ = store.GetPending(ctx, handle)
+	if want, have := oasis.StageToAuthorize, found.Stage; want != have {
+		t.Errorf("UpdatePending: expected %#v, got %#v", want, have)
+	}
+	if want, have := "dummy-user", found.UserID; want != have {
+		t.Errorf("UpdatePending: expected %#v, got %#v", want, have)
+	}
+
+	// update from a stale stage
+	stale := *found
+	stale.UserID = "evil-user"
+	if err := store.UpdatePending(ctx, handle, oasis.StageInitialize, &stale); err != oasis.ErrPendingConflict {
+		t.Errorf("UpdatePending: expected ErrPendingConflict, got %#v", err)
+	}
+	found, _ = store.GetPending(ctx, handle)
+	if want, have := "dummy-user", found.UserID; want != have {
+		t.Errorf("UpdatePending: expected %#v, got %#v", want, have)
+	}
+	if err := store.UpdatePending(ctx, "unknown-handle", oasis.StageInitialize, &stale); err != oasis.ErrPendingNotFound {
+		t.Errorf("UpdatePending: expected ErrPendingNotFound, got %#v", err)
+	}
+
+	// concurrent updates from the same stage
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	success := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			next := *found
+			next.Stage = oasis.StageCustom
+			if err := store.UpdatePending(ctx, handle, oasis.StageToAuthorize, &next); err == nil {
+				mutex.Lock()
+				success++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if want, have := 1, success; want != have {
+		t.Errorf("UpdatePending: expected %d concurrent update to succeed, got %d", want, have)
+	}
+
+	// delete
+	if err := store.DeletePending(ctx, handle); err != nil {
+		t.Errorf("DeletePending: unexpected error: %s", err)
+	}
+	if _, err := store.GetPending(ctx, handle); err != oasis.ErrPendingNotFound {
+		t.Errorf("DeletePending: expected ErrPendingNotFound, got %#v", err)
+	}
+	if err := store.DeletePending(ctx, handle); err != oasis.ErrPendingNotFound {
+		t.Errorf("DeletePending: expected ErrPendingNotFound, got %#v", err)
+	}
+	if _, err := store.GetPending(ctx, other); err != nil {
+		t.Errorf("DeletePending: expected other handle untouched, got %#v", err)
+	}
+}
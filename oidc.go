@@ -0,0 +1,220 @@
+package oasis
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScopeOpenID is the scope that makes an authorization request an
+// OpenID Connect Authentication Request (OpenID Connect Core 1.0
+// section 3.1.2.1).
+const ScopeOpenID = "openid"
+
+// Values of the prompt parameter of OpenID Connect
+// Authentication Request.
+const (
+	PromptNone          = "none"
+	PromptLogin         = "login"
+	PromptConsent       = "consent"
+	PromptSelectAccount = "select_account"
+)
+
+// DefaultIDTokenTTL is the lifetime of ID Tokens
+// if not set in Context.
+const DefaultIDTokenTTL = time.Hour
+
+// HasPrompt reports whether the prompt parameter of the
+// request contains the value.
+func (ar *AuthorizeRequest) HasPrompt(value string) bool {
+	return containsString(strings.Fields(ar.Prompt), value)
+}
+
+// AuthTimeAllowed reports whether an authentication of the user at
+// authTime satisfies the max_age of the request, i.e. it is at most
+// max_age seconds before the request is received (OpenID Connect Core
+// 1.0 section 3.1.2.1). A handler reusing an earlier authentication,
+// e.g. of a session, must actively re-authenticate the user if not.
+func (ar *AuthorizeRequest) AuthTimeAllowed(authTime time.Time) bool {
+	if ar.MaxAge == nil {
+		return true
+	}
+	requestedAt := time.Now()
+	if ar.RequestedAt != nil {
+		requestedAt = *ar.RequestedAt
+	}
+	return !authTime.Before(requestedAt.Add(-time.Duration(*ar.MaxAge) * time.Second))
+}
+
+// IsOpenID reports whether the openid scope is granted
+// to the request.
+func (ar *AuthorizeRequest) IsOpenID() bool {
+	return containsString(strings.Fields(ar.Scope), ScopeOpenID)
+}
+
+// decodeOIDC decodes the parameters of OpenID Connect
+// Authentication Request (OpenID Connect Core 1.0
+// section 3.1.2.1) into the AuthorizeRequest.
+func decodeOIDC(ar *AuthorizeRequest, query url.Values) *Error {
+	ar.Nonce = query.Get("nonce")
+	ar.Prompt = strings.Trim(query.Get("prompt"), "\r\n\t ")
+	ar.ACRValues = strings.Trim(query.Get("acr_values"), "\r\n\t ")
+	ar.LoginHint = query.Get("login_hint")
+	ar.IDTokenHint = strings.Trim(query.Get("id_token_hint"), "\r\n\t ")
+
+	for _, prompt := range strings.Fields(ar.Prompt) {
+		switch prompt {
+		case PromptNone, PromptLogin, PromptConsent, PromptSelectAccount:
+		default:
+			return NewError(ErrInvalidRequest, fmt.Sprintf(`prompt "%s" is not supported`, prompt))
+		}
+	}
+	if ar.HasPrompt(PromptNone) && len(strings.Fields(ar.Prompt)) > 1 {
+		return NewError(ErrInvalidRequest, `prompt "none" must not be combined with other values`)
+	}
+
+	if raw := strings.Trim(query.Get("max_age"), "\r\n\t "); raw != "" {
+		maxAge, err := strconv.Atoi(raw)
+		if err != nil || maxAge < 0 {
+			return NewError(ErrInvalidRequest, "max_age must be a non-negative integer")
+		}
+		requestedAt := time.Now()
+		ar.MaxAge, ar.RequestedAt = &maxAge, &requestedAt
+	}
+	return nil
+}
+
+// Claims are the claims about a user, as described in
+// OpenID Connect Core 1.0 section 5.1.
+type Claims map[string]interface{}
+
+// ClaimsProvider provides the claims about users.
+type ClaimsProvider interface {
+
+	// GetClaims returns the claims of the user. Only the named
+	// claims are requested. Claims not requested are ignored.
+	GetClaims(ctx context.Context, userID string, names []string) (Claims, error)
+}
+
+// ClaimsProviderFunc is the function type of ClaimsProvider.
+type ClaimsProviderFunc func(ctx context.Context, userID string, names []string) (Claims, error)
+
+// GetClaims implements ClaimsProvider
+func (f ClaimsProviderFunc) GetClaims(ctx context.Context, userID string, names []string) (Claims, error) {
+	return f(ctx, userID, names)
+}
+
+// ScopeClaims maps the standard scopes to the claims they
+// request, as described in OpenID Connect Core 1.0 section 5.4.
+var ScopeClaims = map[string][]string{
+	"profile": {
+		"name", "family_name", "given_name", "middle_name", "nickname",
+		"preferred_username", "profile", "picture", "website", "gender",
+		"birthdate", "zoneinfo", "locale", "updated_at",
+	},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+// scopeClaims returns the claims requested by the scope,
+// and the claims of the ClaimsProvider of the actx.
+func scopeClaims(ctx context.Context, actx *Context, userID string, scope Scope) (Claims, error) {
+	var names []string
+	for _, token := range scope {
+		names = append(names, ScopeClaims[token]...)
+	}
+	if len(names) == 0 || actx.ClaimsProvider == nil {
+		return Claims{}, nil
+	}
+
+	provided, err := actx.ClaimsProvider.GetClaims(ctx, userID, names)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(Claims)
+	for _, name := range names {
+		if value, ok := provided[name]; ok {
+			claims[name] = value
+		}
+	}
+	return claims, nil
+}
+
+// NewIDToken produces an ID Token (OpenID Connect Core 1.0
+// section 2) for the authenticated user of the authorized request,
+// signed by the IDTokenSigner of the *Context in ctx.
+//
+// The subject is the UserID of the request. The nonce and
+// auth_time are set if the request has them. If accessToken
+// is not empty, its hash is set as the at_hash claim. Claims
+// requested by the scope (see ScopeClaims) are added from the
+// ClaimsProvider, if set.
+//
+// The c_hash claim is not supported, as the hybrid flow (e.g.
+// response_type "code id_token") is out of scope of this package.
+func NewIDToken(ctx context.Context, ar *AuthorizeRequest, accessToken string) (string, error) {
+	actx := contextWithDefaults(ctx)
+	if actx == nil || actx.IDTokenSigner == nil {
+		return "", fmt.Errorf("id token signer is required but not set in context")
+	}
+	if ar.UserID == "" {
+		return "", fmt.Errorf("authorize request is not authenticated")
+	}
+
+	scope, err := ParseScope(ar.Scope)
+	if err != nil {
+		return "", err
+	}
+	claims, err := scopeClaims(ctx, actx, ar.UserID, scope)
+	if err != nil {
+		return "", err
+	}
+
+	// JWT NumericDate has a resolution of seconds
+	now := time.Now().Truncate(time.Second)
+	claims["iss"] = actx.Issuer
+	claims["sub"] = ar.UserID
+	claims["aud"] = ar.ClientID
+	claims["exp"] = now.Add(actx.IDTokenTTL).Unix()
+	claims["iat"] = now.Unix()
+	if ar.AuthTime != nil {
+		claims["auth_time"] = ar.AuthTime.Unix()
+	}
+	if ar.Nonce != "" {
+		claims["nonce"] = ar.Nonce
+	}
+
+	if accessToken != "" {
+		if claims["at_hash"], err = halfHash(actx.IDTokenSigner.Algorithm(), accessToken); err != nil {
+			return "", err
+		}
+	}
+	return actx.IDTokenSigner.SignJWT("JWT", claims)
+}
+
+// halfHash returns the base64url encoded left-most half of the
+// hash of value, with the hash algorithm of the JWS alg, as
+// described in OpenID Connect Core 1.0 section 3.1.3.6.
+func halfHash(alg, value string) (string, error) {
+	var h hash.Hash
+	switch alg {
+	case AlgorithmHS256, AlgorithmRS256, AlgorithmES256:
+		h = sha256.New()
+	case AlgorithmEdDSA:
+		// Ed25519 uses SHA-512 internally
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
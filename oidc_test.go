@@ -0,0 +1,281 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestAuthorizeDecoder_OIDC(t *testing.T) {
+	decoder := oasis.NewAuthorizeDecoder("code")
+	decode := func(params url.Values) (*oasis.AuthorizeRequest, error) {
+		query := url.Values{
+			"response_type": {"code"},
+			"client_id":     {"dummy-client"},
+			"scope":         {"openid profile"},
+		}
+		for key, values := range params {
+			query[key] = values
+		}
+		r, _ := http.NewRequest("GET", "/authorize?"+query.Encode(), nil)
+		_, ar, err := decoder.DecodeAuthorize(r)
+		return ar, err
+	}
+
+	ar, err := decode(url.Values{
+		"nonce":         {"dummy-nonce"},
+		"prompt":        {"login consent"},
+		"max_age":       {"300"},
+		"acr_values":    {"urn:mace:incommon:iap:silver"},
+		"login_hint":    {"dummy@foobar.com"},
+		"id_token_hint": {"dummy.id.token"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ar.IsOpenID() {
+		t.Errorf("expected openid request")
+	}
+	if want, have := "dummy-nonce", ar.Nonce; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !ar.HasPrompt(oasis.PromptLogin) || !ar.HasPrompt(oasis.PromptConsent) || ar.HasPrompt(oasis.PromptNone) {
+		t.Errorf("unexpected prompt %#v", ar.Prompt)
+	}
+	if ar.MaxAge == nil || *ar.MaxAge != 300 {
+		t.Errorf("expected max_age 300, got %#v", ar.MaxAge)
+	}
+	if ar.RequestedAt == nil {
+		t.Errorf("expected the request time with max_age, got nil")
+	}
+	if want, have := "urn:mace:incommon:iap:silver", ar.ACRValues; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "dummy@foobar.com", ar.LoginHint; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "dummy.id.token", ar.IDTokenHint; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	tests := []struct {
+		params        url.Values
+		expectedError string
+	}{
+		{url.Values{"prompt": {"none login"}}, `invalid_request: prompt "none" must not be combined with other values`},
+		{url.Values{"prompt": {"later"}}, `invalid_request: prompt "later" is not supported`},
+		{url.Values{"max_age": {"-1"}}, "invalid_request: max_age must be a non-negative integer"},
+		{url.Values{"max_age": {"soon"}}, "invalid_request: max_age must be a non-negative integer"},
+	}
+	for _, test := range tests {
+		_, err := decode(test.params)
+		if err == nil {
+			t.Errorf("%v: expected error, got nil", test.params)
+		} else if want, have := test.expectedError, err.Error(); want != have {
+			t.Errorf("%v: expected %#v, got %#v", test.params, want, have)
+		}
+	}
+}
+
+func TestNewIDToken(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	signer, _ := oasis.NewSigningKey("dummy-key", key)
+	var requested []string
+	actx := oasis.Context{
+		TokenStorage:  oasis.NewMemoryTokenStorage(),
+		Issuer:        "https://foobar.com",
+		IDTokenSigner: signer,
+		ClaimsProvider: oasis.ClaimsProviderFunc(func(ctx context.Context, userID string, names []string) (oasis.Claims, error) {
+			requested = names
+			return oasis.Claims{
+				"email":          "dummy@foobar.com",
+				"email_verified": true,
+				"phone_number":   "+1 555 0100",
+				"sub":            "spoofed-user",
+			}, nil
+		}),
+	}
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	authorizeEndpoint := oasis.NewAuthorizeEndpoint(
+		actx,
+		oasis.NewAuthorizeDecoder("code"),
+		oasis.AuthorizeHandlerFunc(func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+			ar.UserID = "dummy-user"
+			ar.AuthTime = &authTime
+			rr, err := oasis.NewAuthorizationCodeResponse(ctx, ar)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			return rr
+		}),
+		oasis.NewResponseEncoder(),
+	)
+	tokenEndpoint := oasis.NewTokenEndpoint(
+		actx,
+		oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+		oasis.NewAuthorizationCodeHandler(),
+		oasis.NewResponseEncoder(),
+	)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dummy-client"},
+		"redirect_uri":  {"https://client.foobar.com/cb"},
+		"scope":         {"openid email"},
+		"nonce":         {"dummy-nonce"},
+	}
+	w := httptest.NewRecorder()
+	authorizeEndpoint.ServeHTTP(w, httptest.NewRequest("GET", "https://foobar.com/authorize?"+query.Encode(), nil))
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	form := url.Values{
+		"grant_type":   {oasis.GrantTypeAuthorizationCode},
+		"code":         {location.Query().Get("code")},
+		"redirect_uri": {"https://client.foobar.com/cb"},
+		"client_id":    {"dummy-client"},
+	}
+	r := httptest.NewRequest("POST", "https://foobar.com/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	tokenEndpoint.ServeHTTP(w, r)
+
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	idToken, _ := body["id_token"].(string)
+	if idToken == "" {
+		t.Fatalf("expected id_token, got %s", w.Body.String())
+	}
+	header, claims := verifyJWT(t, idToken, key)
+	if want, have := "dummy-key", header["kid"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	accessToken := body["access_token"].(string)
+	sum := sha256.Sum256([]byte(accessToken))
+	expected := map[string]interface{}{
+		"iss":            "https://foobar.com",
+		"sub":            "dummy-user",
+		"aud":            "dummy-client",
+		"nonce":          "dummy-nonce",
+		"auth_time":      float64(authTime.Unix()),
+		"at_hash":        base64.RawURLEncoding.EncodeToString(sum[:16]),
+		"email":          "dummy@foobar.com",
+		"email_verified": true,
+	}
+	for name, value := range expected {
+		if want, have := value, claims[name]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", name, want, have)
+		}
+	}
+	if _, ok := claims["phone_number"]; ok {
+		t.Errorf("unexpected claim phone_number")
+	}
+	if want, have := "email,email_verified", strings.Join(requested, ","); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestNewIDToken_minimal(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	signer, _ := oasis.NewSigningKey("", key)
+	ctx := oasis.WithContext(context.Background(), &oasis.Context{IDTokenSigner: signer})
+
+	idToken, err := oasis.NewIDToken(ctx, &oasis.AuthorizeRequest{
+		ClientID: "dummy-client",
+		Scope:    "openid",
+		UserID:   "dummy-user",
+	}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, claims := verifyJWT(t, idToken, key)
+	if want, have := "dummy-user", claims["sub"]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for _, name := range []string{"at_hash", "c_hash", "nonce", "auth_time"} {
+		if _, ok := claims[name]; ok {
+			t.Errorf("unexpected claim %s", name)
+		}
+	}
+
+	// not authenticated
+	if _, err = oasis.NewIDToken(ctx, &oasis.AuthorizeRequest{ClientID: "dummy-client"}, ""); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestNewAuthorizationCodeResponse_maxAge(t *testing.T) {
+	ctx := oasis.WithContext(context.Background(), &oasis.Context{TokenStorage: oasis.NewMemoryTokenStorage()})
+	requestedAt := time.Now()
+	maxAge := 60
+	at := func(d time.Duration) *time.Time {
+		authTime := requestedAt.Add(d)
+		return &authTime
+	}
+
+	tests := []struct {
+		desc          string
+		maxAge        *int
+		authTime      *time.Time
+		expectedError string
+	}{
+		{
+			desc: "no max_age",
+		},
+		{
+			desc:     "authenticated within max_age",
+			maxAge:   &maxAge,
+			authTime: at(-30 * time.Second),
+		},
+		{
+			desc:     "authenticated after the request",
+			maxAge:   &maxAge,
+			authTime: at(5 * time.Minute),
+		},
+		{
+			desc:          "authentication too old",
+			maxAge:        &maxAge,
+			authTime:      at(-2 * time.Minute),
+			expectedError: "login_required: user is not authenticated within max_age",
+		},
+		{
+			desc:          "unknown auth_time",
+			maxAge:        &maxAge,
+			expectedError: "login_required: user is not authenticated within max_age",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := oasis.NewAuthorizationCodeResponse(ctx, &oasis.AuthorizeRequest{
+			ResponseType: "code",
+			ClientID:     "dummy-client",
+			RedirectURI:  "https://client.foobar.com/cb",
+			UserID:       "dummy-user",
+			MaxAge:       test.maxAge,
+			AuthTime:     test.authTime,
+			RequestedAt:  &requestedAt,
+		})
+		if test.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.desc)
+		} else if want, have := test.expectedError, err.Error(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
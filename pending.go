@@ -0,0 +1,206 @@
+package oasis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PendingHandleParam is the name of the HttpOnly cookie
+// that carries the handle of a pending authorization between stages.
+const PendingHandleParam = "oasis_handle"
+
+// Errors returned by PendingAuthorizationStore.
+var (
+	// ErrPendingNotFound is returned when the handle does not
+	// exist or has expired.
+	ErrPendingNotFound = errors.New("pending authorization not found")
+
+	// ErrPendingConflict is returned when the stored stage is
+	// not the expected one, i.e. the pending authorization has
+	// been moved to another stage by another request.
+	ErrPendingConflict = errors.New("pending authorization stage conflict")
+)
+
+// PendingAuthorizationStore stores in-flight AuthorizeRequest on
+// the server side, under an opaque handle. Only the handle needs to
+// be passed through the browser between authorization stages.
+//
+// Implementations must be safe for concurrent use. The
+// oasistest package provides a conformance test for them.
+type PendingAuthorizationStore interface {
+
+	// CreatePending saves the AuthorizeRequest under a new
+	// random handle, which expires after a while.
+	CreatePending(ctx context.Context, ar *AuthorizeRequest) (handle string, err error)
+
+	// GetPending retrieves the AuthorizeRequest of the handle.
+	// Returns ErrPendingNotFound if there is no such handle.
+	GetPending(ctx context.Context, handle string) (*AuthorizeRequest, error)
+
+	// UpdatePending atomically replaces the AuthorizeRequest of the
+	// handle, only if the stored one is at stage from. Returns
+	// ErrPendingConflict if not, or ErrPendingNotFound if there
+	// is no such handle.
+	UpdatePending(ctx context.Context, handle string, from AuthorizeStage, ar *AuthorizeRequest) error
+
+	// DeletePending removes the handle. Returns ErrPendingNotFound
+	// if there is no such handle.
+	DeletePending(ctx context.Context, handle string) error
+}
+
+// AdvancePending moves the pending authorization of ar to the stage
+// to, if it is still at ar.Stage in the PendingAuthorizationStore of
+// the *Context in ctx. On success, ar.Stage is set to to.
+//
+// Only one of the concurrent requests (e.g. from two browser tabs)
+// of the same pending authorization can advance it. The others get
+// ErrPendingConflict.
+//
+// If the transition is not allowed by the AuthorizeHandlerMux
+// calling the handler, a *StageError is returned and nothing
+// is saved (see CheckStageTransition).
+func AdvancePending(ctx context.Context, ar *AuthorizeRequest, to AuthorizeStage) error {
+	actx := GetContext(ctx)
+	if actx == nil || actx.PendingAuthorizationStore == nil {
+		return fmt.Errorf("pending authorization store is required but not set in context")
+	}
+	if ar.PendingHandle == "" {
+		return ErrPendingNotFound
+	}
+	if err := CheckStageTransition(ctx, ar.Stage, to); err != nil {
+		return err
+	}
+
+	next := *ar
+	next.Stage = to
+	if err := actx.UpdatePending(ctx, ar.PendingHandle, ar.Stage, &next); err != nil {
+		return err
+	}
+	ar.Stage = to
+	return nil
+}
+
+// newHandle returns a random opaque handle.
+func newHandle() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("unable to read random bytes. %s", err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+type memoryPending struct {
+	ar        AuthorizeRequest
+	expiresAt time.Time
+}
+
+// MemoryPendingAuthorizationStore is an in-memory
+// PendingAuthorizationStore implementation.
+//
+// It is safe for concurrent use. As pending authorizations
+// are lost on restart and not shared between processes, it
+// is mainly intended for testing and single instance deployment.
+type MemoryPendingAuthorizationStore struct {
+	mutex   sync.Mutex
+	pending map[string]*memoryPending
+	ttl     time.Duration
+}
+
+// NewMemoryPendingAuthorizationStore returns an initialized
+// *MemoryPendingAuthorizationStore. Handles expire after ttl.
+func NewMemoryPendingAuthorizationStore(ttl time.Duration) *MemoryPendingAuthorizationStore {
+	return &MemoryPendingAuthorizationStore{
+		pending: make(map[string]*memoryPending),
+		ttl:     ttl,
+	}
+}
+
+// CreatePending implements PendingAuthorizationStore
+func (store *MemoryPendingAuthorizationStore) CreatePending(ctx context.Context, ar *AuthorizeRequest) (handle string, err error) {
+	if handle, err = newHandle(); err != nil {
+		return
+	}
+
+	stored := &memoryPending{ar: *ar, expiresAt: time.Now().Add(store.ttl)}
+	stored.ar.HTTPRequest = nil
+	stored.ar.PendingHandle = handle
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purge()
+	store.pending[handle] = stored
+	return
+}
+
+// GetPending implements PendingAuthorizationStore
+func (store *MemoryPendingAuthorizationStore) GetPending(ctx context.Context, handle string) (*AuthorizeRequest, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored, err := store.get(handle)
+	if err != nil {
+		return nil, err
+	}
+	ar := stored.ar
+	return &ar, nil
+}
+
+// UpdatePending implements PendingAuthorizationStore
+func (store *MemoryPendingAuthorizationStore) UpdatePending(ctx context.Context, handle string, from AuthorizeStage, ar *AuthorizeRequest) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored, err := store.get(handle)
+	if err != nil {
+		return err
+	}
+	if stored.ar.Stage != from {
+		return ErrPendingConflict
+	}
+	stored.ar = *ar
+	stored.ar.HTTPRequest = nil
+	stored.ar.PendingHandle = handle
+	return nil
+}
+
+// DeletePending implements PendingAuthorizationStore
+func (store *MemoryPendingAuthorizationStore) DeletePending(ctx context.Context, handle string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, err := store.get(handle); err != nil {
+		return err
+	}
+	delete(store.pending, handle)
+	return nil
+}
+
+// get returns the unexpired pending authorization of the handle.
+// The caller must hold the mutex.
+func (store *MemoryPendingAuthorizationStore) get(handle string) (*memoryPending, error) {
+	stored, ok := store.pending[handle]
+	if !ok {
+		return nil, ErrPendingNotFound
+	}
+	if !time.Now().Before(stored.expiresAt) {
+		delete(store.pending, handle)
+		return nil, ErrPendingNotFound
+	}
+	return stored, nil
+}
+
+// purge removes all expired pending authorizations.
+// The caller must hold the mutex.
+func (store *MemoryPendingAuthorizationStore) purge() {
+	now := time.Now()
+	for handle, stored := range store.pending {
+		if !now.Before(stored.expiresAt) {
+			delete(store.pending, handle)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package oasis_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+	"github.com/go-oasis/oasis/oasistest"
+)
+
+func TestMemoryPendingAuthorizationStore(t *testing.T) {
+	oasistest.TestPendingAuthorizationStore(t, oasis.NewMemoryPendingAuthorizationStore(time.Minute))
+}
+
+func TestMemoryPendingAuthorizationStore_expiry(t *testing.T) {
+	store := oasis.NewMemoryPendingAuthorizationStore(time.Millisecond)
+	handle, _ := store.CreatePending(context.Background(), &oasis.AuthorizeRequest{ClientID: "dummy-client"})
+	time.Sleep(5 * time.Millisecond)
+	if _, err := store.GetPending(context.Background(), handle); err != oasis.ErrPendingNotFound {
+		t.Errorf("expected ErrPendingNotFound, got %#v", err)
+	}
+}
+
+func TestAuthorizeDecoder_PendingAuthorizationStore(t *testing.T) {
+	store := oasis.NewMemoryPendingAuthorizationStore(time.Minute)
+	actx := &oasis.Context{
+		ClientStore:               newTestClientStore(),
+		PendingAuthorizationStore: store,
+	}
+	decoder := oasis.NewAuthorizeDecoder("code")
+
+	handle, _ := store.CreatePending(context.Background(), &oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Stage:        oasis.StageToAuthenticate,
+	})
+
+	decode := func() (*oasis.AuthorizeRequest, error) {
+		r, _ := http.NewRequest("GET", "/foobar/authorize", nil)
+		r.AddCookie(&http.Cookie{Name: oasis.PendingHandleParam, Value: handle})
+		ctx := oasis.WithContext(r.Context(), actx)
+		_, ar, err := decoder.DecodeAuthorize(r.WithContext(ctx))
+		return ar, err
+	}
+
+	// two tabs with the same handle
+	tab1, err := decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tab2, _ := decode()
+	if want, have := handle, tab1.PendingHandle; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	ctx := oasis.WithContext(context.Background(), actx)
+	tab1.UserID = "dummy-user"
+	if err := oasis.AdvancePending(ctx, tab1, oasis.StageToAuthorize); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if want, have := oasis.StageToAuthorize, tab1.Stage; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	tab2.UserID = "evil-user"
+	if err := oasis.AdvancePending(ctx, tab2, oasis.StageToAuthorize); err != oasis.ErrPendingConflict {
+		t.Errorf("expected ErrPendingConflict, got %#v", err)
+	}
+	if want, have := oasis.StageToAuthenticate, tab2.Stage; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the next round trip sees the advanced request
+	ar, err := decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := oasis.StageToAuthorize, ar.Stage; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "dummy-user", ar.UserID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// expired handle
+	store.DeletePending(context.Background(), handle)
+	if _, err := decode(); err == nil {
+		t.Errorf("expected error, got nil")
+	} else if want, have := "invalid_request: authorization session has expired", err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,111 @@
+package oasis
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+)
+
+// Code challenge methods of PKCE as described
+// in RFC7636 section 4.2.
+const (
+	CodeChallengeMethodPlain = "plain"
+	CodeChallengeMethodS256  = "S256"
+)
+
+// PKCEPolicy is the policy of Proof Key for Code Exchange
+// (RFC7636) applied to the authorization requests of a client.
+//
+// The zero value accepts requests with or without PKCE, and
+// with either code challenge method.
+type PKCEPolicy struct {
+
+	// Required rejects authorization requests without
+	// code_challenge. Should be set for public clients.
+	Required bool
+
+	// ForbidPlain rejects authorization requests with
+	// the "plain" code_challenge_method.
+	ForbidPlain bool
+}
+
+// getPKCEPolicy returns the PKCEPolicy for the client from the
+// *Context in ctx, or the one of the client registration. Returns
+// the zero policy if neither is set.
+func getPKCEPolicy(ctx context.Context, client *Client) PKCEPolicy {
+	if actx := GetContext(ctx); actx != nil && actx.PKCEPolicy != nil {
+		return actx.PKCEPolicy(ctx, client.ID)
+	}
+	return client.PKCE
+}
+
+// validatePKCE validates code_challenge and code_challenge_method
+// of the AuthorizeRequest against the policy. If code_challenge_method
+// is not provided, it is set to "plain" (RFC7636 section 4.3).
+func validatePKCE(ar *AuthorizeRequest, policy PKCEPolicy) *Error {
+	if ar.CodeChallenge == "" {
+		if ar.CodeChallengeMethod != "" {
+			return NewError(ErrInvalidRequest, "code_challenge_method is set but code_challenge is not")
+		}
+		if policy.Required {
+			return NewError(ErrInvalidRequest, "code_challenge is required but not set")
+		}
+		return nil
+	}
+
+	if ar.CodeChallengeMethod == "" {
+		ar.CodeChallengeMethod = CodeChallengeMethodPlain
+	}
+	switch ar.CodeChallengeMethod {
+	case CodeChallengeMethodPlain:
+		if policy.ForbidPlain {
+			return NewError(ErrInvalidRequest, `code_challenge_method "plain" is not allowed`)
+		}
+	case CodeChallengeMethodS256:
+	default:
+		return NewError(ErrInvalidRequest, fmt.Sprintf(`code_challenge_method "%s" is not supported`, ar.CodeChallengeMethod))
+	}
+	if !isPKCEString(ar.CodeChallenge) {
+		return NewError(ErrInvalidRequest, "code_challenge is misformed")
+	}
+	return nil
+}
+
+// verifyCodeVerifier reports whether the code_verifier matches
+// the code_challenge as described in RFC7636 section 4.6.
+func verifyCodeVerifier(verifier, challenge, method string) bool {
+	if !isPKCEString(verifier) {
+		return false
+	}
+	switch method {
+	case CodeChallengeMethodPlain:
+		return subtle.ConstantTimeCompare([]byte(verifier), []byte(challenge)) == 1
+	case CodeChallengeMethodS256:
+		digest := sha256.Sum256([]byte(verifier))
+		computed := base64.RawURLEncoding.EncodeToString(digest[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+	}
+	return false
+}
+
+// isPKCEString reports whether s is a valid code_verifier or
+// code_challenge, i.e. 43 to 128 characters of
+// [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~"
+// (RFC7636 section 4.1 and 4.2).
+func isPKCEString(s string) bool {
+	if len(s) < 43 || len(s) > 128 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package oasis_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+// code verifier and challenge from RFC7636 Appendix B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestAuthorizeDecoder_PKCE(t *testing.T) {
+	actx := &oasis.Context{
+		PKCEPolicy: func(ctx context.Context, clientID string) oasis.PKCEPolicy {
+			if clientID == "public-client" {
+				return oasis.PKCEPolicy{Required: true, ForbidPlain: true}
+			}
+			return oasis.PKCEPolicy{}
+		},
+	}
+	decoder := oasis.NewAuthorizeDecoder("code")
+
+	tests := []struct {
+		desc           string
+		query          url.Values
+		expectedMethod string
+		expectedError  string
+	}{
+		{
+			desc: "S256",
+			query: url.Values{
+				"client_id":             {"public-client"},
+				"code_challenge":        {testCodeChallenge},
+				"code_challenge_method": {"S256"},
+			},
+			expectedMethod: "S256",
+		},
+		{
+			desc: "default to plain",
+			query: url.Values{
+				"client_id":      {"dummy-client"},
+				"code_challenge": {testCodeVerifier},
+			},
+			expectedMethod: "plain",
+		},
+		{
+			desc:  "PKCE optional",
+			query: url.Values{"client_id": {"dummy-client"}},
+		},
+		{
+			desc:          "PKCE required",
+			query:         url.Values{"client_id": {"public-client"}},
+			expectedError: "invalid_request: code_challenge is required but not set",
+		},
+		{
+			desc: "plain forbidden",
+			query: url.Values{
+				"client_id":             {"public-client"},
+				"code_challenge":        {testCodeVerifier},
+				"code_challenge_method": {"plain"},
+			},
+			expectedError: `invalid_request: code_challenge_method "plain" is not allowed`,
+		},
+		{
+			desc: "unknown method",
+			query: url.Values{
+				"client_id":             {"dummy-client"},
+				"code_challenge":        {testCodeChallenge},
+				"code_challenge_method": {"S512"},
+			},
+			expectedError: `invalid_request: code_challenge_method "S512" is not supported`,
+		},
+		{
+			desc: "short challenge",
+			query: url.Values{
+				"client_id":      {"dummy-client"},
+				"code_challenge": {"too-short"},
+			},
+			expectedError: "invalid_request: code_challenge is misformed",
+		},
+		{
+			desc: "method without challenge",
+			query: url.Values{
+				"client_id":             {"dummy-client"},
+				"code_challenge_method": {"S256"},
+			},
+			expectedError: "invalid_request: code_challenge_method is set but code_challenge is not",
+		},
+	}
+
+	for _, test := range tests {
+		test.query.Set("response_type", "code")
+		r, _ := http.NewRequest("GET", "/foobar/authorize?"+test.query.Encode(), nil)
+		r = r.WithContext(oasis.WithContext(r.Context(), actx))
+
+		_, ar, err := decoder.DecodeAuthorize(r)
+		if test.expectedError != "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", test.desc)
+			} else if want, have := test.expectedError, err.Error(); want != have {
+				t.Errorf("%s:\nexpected: %#v\ngot:      %#v", test.desc, want, have)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+		}
+		if want, have := test.expectedMethod, ar.CodeChallengeMethod; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestAuthorizationCodeGrant_PKCE(t *testing.T) {
+	actx := oasis.Context{
+		TokenStorage: oasis.NewMemoryTokenStorage(),
+	}
+	tokenEndpoint := oasis.NewTokenEndpoint(
+		actx,
+		oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode),
+		oasis.NewAuthorizationCodeHandler(),
+		oasis.NewResponseEncoder(),
+	)
+
+	tests := []struct {
+		desc          string
+		challenge     string
+		method        string
+		verifier      string
+		expectedError string
+	}{
+		{
+			desc:      "S256",
+			challenge: testCodeChallenge,
+			method:    "S256",
+			verifier:  testCodeVerifier,
+		},
+		{
+			desc:      "plain",
+			challenge: testCodeVerifier,
+			method:    "plain",
+			verifier:  testCodeVerifier,
+		},
+		{
+			desc:          "wrong verifier",
+			challenge:     testCodeChallenge,
+			method:        "S256",
+			verifier:      strings.Repeat("a", 43),
+			expectedError: "invalid_grant",
+		},
+		{
+			desc:          "missing verifier",
+			challenge:     testCodeChallenge,
+			method:        "S256",
+			expectedError: "invalid_request",
+		},
+		{
+			desc:          "verifier without challenge",
+			verifier:      testCodeVerifier,
+			expectedError: "invalid_grant",
+		},
+	}
+
+	for _, test := range tests {
+		rr, err := oasis.NewAuthorizationCodeResponse(oasis.WithContext(context.Background(), &actx), &oasis.AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            "dummy-client",
+			RedirectURI:         "https://client.foobar.com/cb",
+			UserID:              "dummy-user",
+			CodeChallenge:       test.challenge,
+			CodeChallengeMethod: test.method,
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.desc, err)
+		}
+
+		form := url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {rr.Query.Get("code")},
+			"client_id":    {"dummy-client"},
+			"redirect_uri": {"https://client.foobar.com/cb"},
+		}
+		if test.verifier != "" {
+			form.Set("code_verifier", test.verifier)
+		}
+		r, _ := http.NewRequest("POST", "https://foobar.com/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		tokenEndpoint.ServeHTTP(w, r)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if test.expectedError == "" {
+			if want, have := http.StatusOK, w.Code; want != have {
+				t.Errorf("%s: expected %#v, got %#v: %#v", test.desc, want, have, body)
+			}
+			continue
+		}
+		if want, have := test.expectedError, body["error"]; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package oasis
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"html/template"
 	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 )
 
 // ResponseEncoder is the interface for handling
@@ -13,41 +20,257 @@ type ResponseEncoder interface {
 	EncodeResponse(http.ResponseWriter, Responder)
 }
 
+// RequestAwareResponseEncoder is a ResponseEncoder that also takes
+// the request being responded to, e.g. to negotiate the output
+// format. The endpoints call EncodeRequestResponse instead of
+// EncodeResponse if the encoder implements it.
+type RequestAwareResponseEncoder interface {
+	ResponseEncoder
+	EncodeRequestResponse(http.ResponseWriter, *http.Request, Responder)
+}
+
+// encodeResponse encodes the Responder with the encoder, passing
+// the request along if the encoder is a RequestAwareResponseEncoder.
+func encodeResponse(encoder ResponseEncoder, w http.ResponseWriter, r *http.Request, rspr Responder) {
+	if ra, ok := encoder.(RequestAwareResponseEncoder); ok {
+		ra.EncodeRequestResponse(w, r, rspr)
+		return
+	}
+	encoder.EncodeResponse(w, rspr)
+}
+
 // NewResponseEncoder returns the default ResponseEncoder
 // implementation.
 func NewResponseEncoder() ResponseEncoder {
-	return defaultResponseEncoder(0)
+	return &DefaultResponseEncoder{}
 }
 
-type defaultResponseEncoder int
+// Branding is the information to customize
+// the look of the error page.
+type Branding struct {
 
-func (encoder defaultResponseEncoder) EncodeResponse(w http.ResponseWriter, rspr Responder) {
-	err := rspr.ResponseTo(w)
+	// Name of the service. Shown in the page title, if set.
+	Name string
 
-	// if there is a ResponderError, handle the output
-	if rsprErr, ok := err.(ResponderError); ok {
-		w.Header().Add("Content-Type", "text/html;charset=utf-8")
-		w.WriteHeader(rsprErr.Code())
-		fmt.Fprintf(w,
-			`<!DOCTYPE html>
+	// LogoURL is the URL of the logo image, if any.
+	LogoURL string
+
+	// StylesheetURL is the URL of an extra stylesheet, if any.
+	StylesheetURL string
+
+	// SupportURL is the URL of the help or support page, if any.
+	SupportURL string
+}
+
+// ErrorPageData is the data to render the error page template.
+type ErrorPageData struct {
+
+	// Code is the HTTP response code.
+	Code int
+
+	// Status is the text of the HTTP response code.
+	Status string
+
+	// Message is the user understandable message about the error.
+	Message string
+
+	// Detail is the error string.
+	Detail string
+
+	// Branding of the error page.
+	Branding Branding
+}
+
+// DefaultErrorTemplate is the template used to render the
+// error page by DefaultResponseEncoder if no template is set.
+var DefaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
 <html>
 <head>
-<title>%s</title>
+<meta charset="utf-8">
+<title>{{ .Status }}{{ with .Branding.Name }} - {{ . }}{{ end }}</title>
+{{- with .Branding.StylesheetURL }}
+<link rel="stylesheet" href="{{ . }}">
+{{- end }}
 </head>
 <body>
-<h1>%s</h1>
-<p><strong>%s</strong></p>
-<p>%s</p>
+{{- with .Branding.LogoURL }}
+<img src="{{ . }}" alt="{{ $.Branding.Name }}">
+{{- end }}
+<h1>{{ .Status }}</h1>
+<p><strong>{{ .Message }}</strong></p>
+<p>{{ .Detail }}</p>
+{{- with .Branding.SupportURL }}
+<p><a href="{{ . }}">Get help</a></p>
+{{- end }}
 </body>
-</html>`,
-			http.StatusText(rsprErr.Code()),
-			http.StatusText(rsprErr.Code()),
-			rsprErr.Message(),
-			rsprErr.Error(),
+</html>`))
+
+// errorPageHeader are the headers set on every error page. The
+// page should never be cached, sniffed, framed or run any script.
+var errorPageHeader = map[string]string{
+	"Cache-Control":           "no-store",
+	"Pragma":                  "no-cache",
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "no-referrer",
+	"Content-Security-Policy": "default-src 'none'; img-src https: data:; style-src https:; frame-ancestors 'none'; base-uri 'none'; form-action 'none'",
+}
+
+// DefaultResponseEncoder is the default ResponseEncoder implementation.
+// It is a RequestAwareResponseEncoder.
+//
+// If the Responder returns a ResponderError, the error is output
+// according to the Accept header of the request. Browsers (i.e.
+// text/html is explicitly accepted and not less preferred than
+// application/json) and requests without Accept header get an
+// error page rendered with Template. The error message is always
+// escaped by html/template. Other callers get a JSON error in the
+// format of RFC6749 section 5.2.
+//
+// In both cases, the response code is ResponderError.Code().
+type DefaultResponseEncoder struct {
+
+	// Template renders the error page with *ErrorPageData.
+	// DefaultErrorTemplate is used if not set.
+	Template *template.Template
+
+	// Branding is passed to Template with every error page.
+	Branding Branding
+
+	// Logger, if set, logs the errors returned by Responder.
+	Logger Logger
+}
+
+// EncodeResponse implements ResponseEncoder. Without the
+// request, errors are always output as an error page.
+func (encoder *DefaultResponseEncoder) EncodeResponse(w http.ResponseWriter, rspr Responder) {
+	encoder.EncodeRequestResponse(w, nil, rspr)
+}
+
+// EncodeRequestResponse implements RequestAwareResponseEncoder
+func (encoder *DefaultResponseEncoder) EncodeRequestResponse(w http.ResponseWriter, r *http.Request, rspr Responder) {
+	err := rspr.ResponseTo(w)
+
+	// if there is a ResponderError, handle the output
+	if rsprErr, ok := err.(ResponderError); ok {
+		if acceptsHTML(r) {
+			encoder.encodeErrorPage(w, rsprErr)
+		} else {
+			encodeErrorJSON(w, rsprErr)
+		}
+	}
+
+	if err == nil || encoder.Logger == nil {
+		return
+	}
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	if rsprErr, ok := err.(ResponderError); ok {
+		encoder.Logger.LogAttrs(ctx, logLevel(rsprErr.Code()), "error response",
+			slog.Int("status", rsprErr.Code()),
+			slog.String("error", rsprErr.Error()),
 		)
+		return
 	}
 
-	// TODO: log error
+	// the Responder has handled the output itself,
+	// but the error must not go unnoticed
+	encoder.Logger.LogAttrs(ctx, slog.LevelError, "responder failed",
+		slog.String("responder", fmt.Sprintf("%T", rspr)),
+		slog.String("error", err.Error()),
+	)
+}
+
+// encodeErrorPage renders the error page of the ResponderError.
+func (encoder *DefaultResponseEncoder) encodeErrorPage(w http.ResponseWriter, rsprErr ResponderError) {
+	tmpl := encoder.Template
+	if tmpl == nil {
+		tmpl = DefaultErrorTemplate
+	}
+
+	// render to buffer first so a failed template
+	// would not produce a partial page
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &ErrorPageData{
+		Code:     rsprErr.Code(),
+		Status:   http.StatusText(rsprErr.Code()),
+		Message:  rsprErr.Message(),
+		Detail:   rsprErr.Error(),
+		Branding: encoder.Branding,
+	}); err != nil {
+		buf.Reset()
+		template.HTMLEscape(&buf, []byte(http.StatusText(rsprErr.Code())))
+	}
+
+	for key, value := range errorPageHeader {
+		w.Header().Set(key, value)
+	}
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	w.WriteHeader(rsprErr.Code())
+	buf.WriteTo(w)
+}
+
+// encodeErrorJSON writes the ResponderError as a JSON error in
+// the format of RFC6749 section 5.2. A ResponderError that is not
+// an *Error is reported as "invalid_request" or "server_error"
+// by its code, with its message as the description.
+func encodeErrorJSON(w http.ResponseWriter, rsprErr ResponderError) {
+	oerr, ok := rsprErr.(*Error)
+	if !ok {
+		oerr = &Error{
+			ErrorCode:   ErrInvalidRequest,
+			Description: rsprErr.Message(),
+			StatusCode:  rsprErr.Code(),
+		}
+		if rsprErr.Code() >= http.StatusInternalServerError {
+			oerr.ErrorCode = ErrServerError
+		}
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeJSON(w, rsprErr.Code(), oerr)
+}
+
+// acceptsHTML reports whether the request explicitly accepts
+// text/html (or application/xhtml+xml) with a quality not less
+// than application/json. A request accepting only "*/*" is
+// treated as an API call. A request without any Accept header
+// gets the error page, as before the negotiation.
+func acceptsHTML(r *http.Request) bool {
+	if r == nil || len(r.Header.Values("Accept")) == 0 {
+		return true
+	}
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return false
+	}
+
+	htmlQ, jsonQ := -1.0, -1.0
+	for _, header := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if value, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(value, 64); err != nil {
+					continue
+				}
+			}
+			switch mediaType {
+			case "text/html", "application/xhtml+xml":
+				if q > htmlQ {
+					htmlQ = q
+				}
+			case "application/json":
+				if q > jsonQ {
+					jsonQ = q
+				}
+			}
+		}
+	}
+	return htmlQ > 0 && htmlQ >= jsonQ
 }
 
 // Responder represents a common interface to
@@ -141,6 +364,12 @@ type RedirectResponse struct {
 	// as the fragment component (i.e. "#abcd"
 	// at the end of URI).
 	Fragment url.Values
+
+	// Code is the HTTP response code of the redirection.
+	// Defaults to http.StatusTemporaryRedirect. Should be
+	// http.StatusSeeOther when responding to a form post,
+	// so the form would not be posted to the client.
+	Code int
 }
 
 // ResponseTo implements Responder interface
@@ -177,10 +406,19 @@ func (rr *RedirectResponse) ResponseTo(w http.ResponseWriter) (err error) {
 	}
 	redirectURI.RawQuery = query.Encode()
 
-	// parse fragment section
-	redirectURI.Fragment = rr.Fragment.Encode()
+	// append fragment. It is encoded already, so it is
+	// not set to redirectURI.Fragment to be escaped again.
+	redirectURI.Fragment = ""
+	location := redirectURI.String()
+	if fragment := rr.Fragment.Encode(); fragment != "" {
+		location += "#" + fragment
+	}
 
-	w.Header().Set("Location", redirectURI.String())
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	code := rr.Code
+	if code == 0 {
+		code = http.StatusTemporaryRedirect
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(code)
 	return
 }
@@ -1,6 +1,8 @@
 package oasis_test
 
 import (
+	"context"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -94,3 +96,193 @@ func TestRedirectResponse(t *testing.T) {
 	}
 
 }
+
+func TestResponseEncoder_errorPage(t *testing.T) {
+	rspr := errorResponder{
+		err: oasis.NewError(oasis.ErrUnsupportedResponseType, `response_type "<script>alert(1)</script>" is not allowed`),
+	}
+
+	tests := []struct {
+		desc     string
+		encoder  oasis.ResponseEncoder
+		expected []string
+	}{
+		{
+			desc:    "default template",
+			encoder: oasis.NewResponseEncoder(),
+			expected: []string{
+				"<title>Bad Request</title>",
+				"The requested response type is not supported.",
+				"&lt;script&gt;alert(1)&lt;/script&gt;",
+			},
+		},
+		{
+			desc: "default template with branding",
+			encoder: &oasis.DefaultResponseEncoder{
+				Branding: oasis.Branding{
+					Name:    "Foobar <Login>",
+					LogoURL: "https://foobar.com/logo.png",
+				},
+			},
+			expected: []string{
+				"<title>Bad Request - Foobar &lt;Login&gt;</title>",
+				`<img src="https://foobar.com/logo.png" alt="Foobar &lt;Login&gt;">`,
+			},
+		},
+		{
+			desc: "custom template",
+			encoder: &oasis.DefaultResponseEncoder{
+				Template: template.Must(template.New("custom").Parse(`<p>{{ .Code }} {{ .Detail }}</p>`)),
+			},
+			expected: []string{
+				"<p>400 unsupported_response_type: response_type &#34;&lt;script&gt;alert(1)&lt;/script&gt;&#34; is not allowed</p>",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.encoder.EncodeResponse(w, rspr)
+
+		if want, have := http.StatusBadRequest, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if strings.Contains(w.Body.String(), "<script>") {
+			t.Errorf("%s: unescaped script in body: %s", test.desc, w.Body.String())
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(w.Body.String(), expected) {
+				t.Errorf("%s: expected body to contain %#v, got %s", test.desc, expected, w.Body.String())
+			}
+		}
+		for key, expected := range map[string]string{
+			"Content-Type":           "text/html;charset=utf-8",
+			"Cache-Control":          "no-store",
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "DENY",
+		} {
+			if want, have := expected, w.Header().Get(key); want != have {
+				t.Errorf("%s: %s: expected %#v, got %#v", test.desc, key, want, have)
+			}
+		}
+	}
+}
+
+type plainResponderError struct{}
+
+func (plainResponderError) Code() int       { return http.StatusServiceUnavailable }
+func (plainResponderError) Message() string { return "under maintenance" }
+func (plainResponderError) Error() string   { return "maintenance mode" }
+
+func TestResponseEncoder_negotiation(t *testing.T) {
+	tests := []struct {
+		desc         string
+		accept       string
+		xhr          bool
+		err          error
+		expectedType string
+		expectedBody string
+	}{
+		{
+			desc:         "browser",
+			accept:       "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			err:          oasis.NewError(oasis.ErrInvalidRequest, "client_id is required but not set"),
+			expectedType: "text/html;charset=utf-8",
+		},
+		{
+			desc:         "API client",
+			accept:       "application/json",
+			err:          oasis.NewError(oasis.ErrInvalidRequest, "client_id is required but not set"),
+			expectedType: "application/json;charset=UTF-8",
+			expectedBody: `{"error":"invalid_request","error_description":"client_id is required but not set"}`,
+		},
+		{
+			desc:         "fetch default",
+			accept:       "*/*",
+			err:          oasis.NewError(oasis.ErrInvalidRequest, ""),
+			expectedType: "application/json;charset=UTF-8",
+			expectedBody: `{"error":"invalid_request"}`,
+		},
+		{
+			desc:         "no accept header",
+			err:          oasis.NewError(oasis.ErrInvalidRequest, ""),
+			expectedType: "text/html;charset=utf-8",
+		},
+		{
+			desc:         "json preferred over html",
+			accept:       "text/html;q=0.5, application/json",
+			err:          oasis.NewError(oasis.ErrInvalidRequest, ""),
+			expectedType: "application/json;charset=UTF-8",
+			expectedBody: `{"error":"invalid_request"}`,
+		},
+		{
+			desc:         "XMLHttpRequest",
+			accept:       "text/html",
+			xhr:          true,
+			err:          oasis.NewError(oasis.ErrInvalidRequest, ""),
+			expectedType: "application/json;charset=UTF-8",
+			expectedBody: `{"error":"invalid_request"}`,
+		},
+		{
+			desc:         "other ResponderError",
+			accept:       "application/json",
+			err:          plainResponderError{},
+			expectedType: "application/json;charset=UTF-8",
+			expectedBody: `{"error":"server_error","error_description":"under maintenance"}`,
+		},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "https://foobar.com/authorize", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		if test.xhr {
+			r.Header.Set("X-Requested-With", "XMLHttpRequest")
+		}
+		w := httptest.NewRecorder()
+		oasis.NewResponseEncoder().(oasis.RequestAwareResponseEncoder).EncodeRequestResponse(w, r, errorResponder{err: test.err})
+
+		if want, have := test.err.(oasis.ResponderError).Code(), w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := test.expectedType, w.Header().Get("Content-Type"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if test.expectedBody != "" {
+			if want, have := test.expectedBody, w.Body.String(); want != have {
+				t.Errorf("%s:\nexpected: %s\ngot:      %s", test.desc, want, have)
+			}
+		}
+	}
+}
+
+// legacyEncoder is a ResponseEncoder unaware of the request.
+type legacyEncoder struct {
+	encoded []oasis.Responder
+}
+
+func (encoder *legacyEncoder) EncodeResponse(w http.ResponseWriter, rspr oasis.Responder) {
+	encoder.encoded = append(encoder.encoded, rspr)
+	rspr.ResponseTo(w)
+}
+
+func TestResponseEncoder_legacy(t *testing.T) {
+	encoder := &legacyEncoder{}
+	endpoint := oasis.NewAuthorizeEndpoint(
+		oasis.Context{},
+		oasis.NewAuthorizeDecoder("code"),
+		oasis.AuthorizeHandlerFunc(func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+			return &oasis.ResponseCache{Code: http.StatusNoContent}
+		}),
+		encoder,
+	)
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, httptest.NewRequest("GET", "/authorize?response_type=code&client_id=dummy-client", nil))
+	if want, have := 1, len(encoder.encoded); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusNoContent, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
@@ -0,0 +1,120 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Scope is the scope of an access request, as described by
+// RFC6749 section 3.3. It is a set of scope tokens, kept in the
+// order they are requested.
+type Scope []string
+
+// ParseScope parses the space-delimited scope string. Duplicated
+// tokens are removed. An error is returned if any token contains
+// characters not allowed by RFC6749 section 3.3.
+func ParseScope(s string) (Scope, error) {
+	var scope Scope
+	for _, token := range strings.Split(s, " ") {
+		if token == "" {
+			continue
+		}
+		for i := 0; i < len(token); i++ {
+			// scope-token = 1*( %x21 / %x23-5B / %x5D-7E )
+			if c := token[i]; c < 0x21 || c == 0x22 || c == 0x5c || c > 0x7e {
+				return nil, fmt.Errorf("scope %q contains invalid character %q", token, c)
+			}
+		}
+		if !scope.Contains(token) {
+			scope = append(scope, token)
+		}
+	}
+	return scope, nil
+}
+
+// String formats the scope as a space-delimited string.
+func (scope Scope) String() string {
+	return strings.Join(scope, " ")
+}
+
+// Contains reports whether the scope contains the token.
+func (scope Scope) Contains(token string) bool {
+	for _, t := range scope {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the tokens of the scope that are
+// also in other, in the order of the scope.
+func (scope Scope) Intersect(other Scope) Scope {
+	var intersection Scope
+	for _, token := range scope {
+		if other.Contains(token) {
+			intersection = append(intersection, token)
+		}
+	}
+	return intersection
+}
+
+// IsSubsetOf reports whether all the tokens of the scope are in other.
+func (scope Scope) IsSubsetOf(other Scope) bool {
+	for _, token := range scope {
+		if !other.Contains(token) {
+			return false
+		}
+	}
+	return true
+}
+
+// ScopeValidator limits the scope requested by the client to the
+// scope that may be granted. It returns the granted scope, or an
+// error if the request should be refused with "invalid_scope".
+type ScopeValidator func(ctx context.Context, client *Client, requested Scope) (granted Scope, err error)
+
+// DefaultScopeValidator is the ScopeValidator used if
+// Context.ScopeValidator is not set.
+//
+// If the client has Scopes, the requested scope is narrowed down to
+// them. The request is refused if none of the requested tokens may
+// be granted. Clients without Scopes may request any scope.
+func DefaultScopeValidator(ctx context.Context, client *Client, requested Scope) (Scope, error) {
+	if len(client.Scopes) == 0 {
+		return requested, nil
+	}
+	granted := requested.Intersect(client.Scopes)
+	if len(requested) > 0 && len(granted) == 0 {
+		return nil, fmt.Errorf(`scope "%s" is not allowed for the client`, requested)
+	}
+	return granted, nil
+}
+
+// validateScope parses the scope of the AuthorizeRequest and
+// replaces it with the scope granted by the ScopeValidator of
+// the *Context in ctx. If the scope is narrowed down, the
+// requested one is kept in RequestedScope.
+func validateScope(ctx context.Context, client *Client, ar *AuthorizeRequest) *Error {
+	requested, err := ParseScope(ar.Scope)
+	if err != nil {
+		return NewError(ErrInvalidScope, err.Error())
+	}
+
+	validator := DefaultScopeValidator
+	if actx := GetContext(ctx); actx != nil && actx.ScopeValidator != nil {
+		validator = actx.ScopeValidator
+	}
+	granted, err := validator(ctx, client, requested)
+	if oerr, ok := err.(*Error); ok {
+		return oerr
+	} else if err != nil {
+		return NewError(ErrInvalidScope, err.Error())
+	}
+	if !requested.IsSubsetOf(granted) && ar.RequestedScope == "" {
+		ar.RequestedScope = requested.String()
+	}
+	ar.Scope = granted.String()
+	return nil
+}
@@ -0,0 +1,178 @@
+package oasis_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      oasis.Scope
+		expectedError bool
+	}{
+		{"", nil, false},
+		{"read", oasis.Scope{"read"}, false},
+		{"read write", oasis.Scope{"read", "write"}, false},
+		{"  read   write read ", oasis.Scope{"read", "write"}, false},
+		{"urn:foo:bar https://foobar.com/api!#$", oasis.Scope{"urn:foo:bar", "https://foobar.com/api!#$"}, false},
+		{`read "write"`, nil, true},
+		{`read\write`, nil, true},
+		{"read\twrite", nil, true},
+		{"réad", nil, true},
+	}
+	for _, test := range tests {
+		scope, err := oasis.ParseScope(test.input)
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("%#v: expected error, got nil", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%#v: unexpected error: %s", test.input, err)
+		}
+		if want, have := test.expected, scope; !reflect.DeepEqual(want, have) {
+			t.Errorf("%#v: expected %#v, got %#v", test.input, want, have)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	scope := oasis.Scope{"read", "write", "admin"}
+	if want, have := "read write admin", scope.String(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !scope.Contains("write") {
+		t.Errorf("expected scope to contain write")
+	}
+	if scope.Contains("delete") {
+		t.Errorf("expected scope not to contain delete")
+	}
+	if want, have := (oasis.Scope{"read", "admin"}), scope.Intersect(oasis.Scope{"admin", "delete", "read"}); !reflect.DeepEqual(want, have) {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if !(oasis.Scope{"admin", "read"}).IsSubsetOf(scope) {
+		t.Errorf("expected subset")
+	}
+	if (oasis.Scope{"admin", "delete"}).IsSubsetOf(scope) {
+		t.Errorf("expected not subset")
+	}
+	if !(oasis.Scope{}).IsSubsetOf(scope) {
+		t.Errorf("expected empty scope to be subset")
+	}
+}
+
+func TestAuthorizeDecoder_scope(t *testing.T) {
+	clientStore := newTestClientStore()
+	clientStore.Add(&oasis.Client{
+		ID:           "scoped-client",
+		RedirectURIs: []string{"https://scoped.foobar.com/cb"},
+		Scopes:       []string{"read", "write"},
+	})
+	decoder := oasis.NewAuthorizeDecoder("code")
+
+	tests := []struct {
+		desc              string
+		validator         oasis.ScopeValidator
+		clientID          string
+		scope             string
+		expectedScope     string
+		expectedRequested string
+		expectedError     string
+	}{
+		{
+			desc:          "any scope for client without scopes",
+			clientID:      "web-client",
+			scope:         "read  delete",
+			expectedScope: "read delete",
+		},
+		{
+			desc:          "invalid syntax",
+			clientID:      "web-client",
+			scope:         `"read"`,
+			expectedError: `invalid_scope: scope "\"read\"" contains invalid character '"'`,
+		},
+		{
+			desc:          "allowed",
+			clientID:      "scoped-client",
+			scope:         "write read",
+			expectedScope: "write read",
+		},
+		{
+			desc:              "narrowed down",
+			clientID:          "scoped-client",
+			scope:             "read delete",
+			expectedScope:     "read",
+			expectedRequested: "read delete",
+		},
+		{
+			desc:          "none allowed",
+			clientID:      "scoped-client",
+			scope:         "delete",
+			expectedError: `invalid_scope: scope "delete" is not allowed for the client`,
+		},
+		{
+			desc:     "custom validator",
+			clientID: "web-client",
+			scope:    "read",
+			validator: func(ctx context.Context, client *oasis.Client, requested oasis.Scope) (oasis.Scope, error) {
+				return nil, errors.New("no scope for you")
+			},
+			expectedError: "invalid_scope: no scope for you",
+		},
+	}
+
+	for _, test := range tests {
+		actx := &oasis.Context{ClientStore: clientStore, ScopeValidator: test.validator}
+		query := url.Values{
+			"response_type": {"code"},
+			"client_id":     {test.clientID},
+			"scope":         {test.scope},
+		}
+		r, _ := http.NewRequest("GET", "/authorize?"+query.Encode(), nil)
+		_, ar, err := decoder.DecodeAuthorize(r.WithContext(oasis.WithContext(r.Context(), actx)))
+		if test.expectedError != "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", test.desc)
+			} else if want, have := test.expectedError, err.Error(); want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+			continue
+		}
+		if want, have := test.expectedScope, ar.Scope; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := test.expectedRequested, ar.RequestedScope; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestNewAuthorizationCodeResponse_scope(t *testing.T) {
+	ctx := oasis.WithContext(context.Background(), &oasis.Context{TokenStorage: oasis.NewMemoryTokenStorage()})
+	rr, err := oasis.NewAuthorizationCodeResponse(ctx, &oasis.AuthorizeRequest{
+		ResponseType:   "code",
+		ClientID:       "scoped-client",
+		RedirectURI:    "https://scoped.foobar.com/cb",
+		Scope:          "read",
+		RequestedScope: "read delete",
+		UserID:         "dummy-user",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := "read", rr.Query.Get("scope"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
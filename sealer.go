@@ -0,0 +1,234 @@
+package oasis
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SealedStateParam is the name of the HttpOnly cookie
+// that carries the sealed AuthorizeRequest between stages.
+const SealedStateParam = "oasis_state"
+
+// Errors returned by StateSealer when opening a sealed value.
+var (
+	ErrSealedStateInvalid = errors.New("sealed state is invalid")
+	ErrSealedStateExpired = errors.New("sealed state has expired")
+)
+
+// ErrSealedStateReplayed is returned by ReplayCache when the
+// sealed value has been used already.
+var ErrSealedStateReplayed = errors.New("sealed state has been used")
+
+// StateSealer turns an AuthorizeRequest into an opaque, tamper-proof
+// string, so it can be passed through the browser (e.g. as a
+// cookie) between authorization stages.
+type StateSealer interface {
+
+	// Seal encodes and seals the AuthorizeRequest, with its
+	// SealedStateID as the id of the sealed value. If it has none,
+	// i.e. it is sealed for the first time, a new unique id is set
+	// first, so all the values sealed for an authorization share
+	// the same id.
+	Seal(ar *AuthorizeRequest) (string, error)
+
+	// Open opens the sealed value and decodes the AuthorizeRequest,
+	// with its SealedStateID set to the id of the sealed value.
+	// Returns ErrSealedStateInvalid if the value is tampered with or
+	// not sealed by a known key, or ErrSealedStateExpired if expired.
+	Open(sealed string) (*AuthorizeRequest, error)
+}
+
+// sealedStateVersion is the format version of the sealed value.
+const sealedStateVersion = 1
+
+// sealedStateKeyIDSize is the size of the key id
+// prefixed to the sealed value.
+const sealedStateKeyIDSize = 4
+
+// sealedState is the payload of the sealed value.
+type sealedState struct {
+	ID        string            `json:"jti"`
+	ExpiresAt time.Time         `json:"exp"`
+	Request   *AuthorizeRequest `json:"ar"`
+}
+
+// sealingKey is an AES-GCM key with its key id.
+type sealingKey struct {
+	id   [sealedStateKeyIDSize]byte
+	aead cipher.AEAD
+}
+
+// AESStateSealer is a StateSealer with AES-GCM.
+//
+// The sealed value is the unpadded base64url encoding of:
+// version (1 byte) || key id (4 bytes) || nonce || ciphertext.
+// The version and key id are authenticated as additional data.
+type AESStateSealer struct {
+	keys []sealingKey
+	ttl  time.Duration
+}
+
+// NewAESStateSealer returns an *AESStateSealer. Sealed values
+// expire after ttl.
+//
+// Each key must be 16, 24 or 32 bytes, to select AES-128, AES-192
+// or AES-256. Values are always sealed with the first key, but can
+// be opened with any of the keys. To rotate keys, put the new key
+// first and keep the old ones until all values they sealed expire.
+func NewAESStateSealer(ttl time.Duration, keys ...[]byte) (*AESStateSealer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least 1 key is required")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive")
+	}
+
+	sealer := &AESStateSealer{ttl: ttl}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d is invalid. %s", i, err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %d is invalid. %s", i, err.Error())
+		}
+
+		// the key id is derived from the key, so it
+		// stays the same regardless of its position
+		sum := sha256.Sum256(key)
+		k := sealingKey{aead: aead}
+		copy(k.id[:], sum[:])
+		sealer.keys = append(sealer.keys, k)
+	}
+	return sealer, nil
+}
+
+// Seal implements StateSealer
+func (sealer *AESStateSealer) Seal(ar *AuthorizeRequest) (string, error) {
+	if ar.SealedStateID == "" {
+		id, err := newHandle()
+		if err != nil {
+			return "", err
+		}
+		ar.SealedStateID = id
+	}
+	plaintext, err := json.Marshal(&sealedState{
+		ID:        ar.SealedStateID,
+		ExpiresAt: time.Now().Add(sealer.ttl),
+		Request:   ar,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to encode request. %s", err.Error())
+	}
+
+	key := sealer.keys[0]
+	header := append([]byte{sealedStateVersion}, key.id[:]...)
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("unable to read random bytes. %s", err.Error())
+	}
+
+	sealed := append(header, nonce...)
+	sealed = key.aead.Seal(sealed, nonce, plaintext, header)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open implements StateSealer
+func (sealer *AESStateSealer) Open(value string) (*AuthorizeRequest, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrSealedStateInvalid
+	}
+	headerSize := 1 + sealedStateKeyIDSize
+	if len(sealed) < headerSize || sealed[0] != sealedStateVersion {
+		return nil, ErrSealedStateInvalid
+	}
+
+	header := sealed[:headerSize]
+	for _, key := range sealer.keys {
+		if string(key.id[:]) != string(header[1:]) {
+			continue
+		}
+		if len(sealed) < headerSize+key.aead.NonceSize() {
+			return nil, ErrSealedStateInvalid
+		}
+		nonce := sealed[headerSize : headerSize+key.aead.NonceSize()]
+		plaintext, err := key.aead.Open(nil, nonce, sealed[headerSize+key.aead.NonceSize():], header)
+		if err != nil {
+			return nil, ErrSealedStateInvalid
+		}
+
+		var state sealedState
+		if err = json.Unmarshal(plaintext, &state); err != nil || state.Request == nil || state.ID == "" {
+			return nil, ErrSealedStateInvalid
+		}
+		if !time.Now().Before(state.ExpiresAt) {
+			return nil, ErrSealedStateExpired
+		}
+		state.Request.SealedStateID = state.ID
+		return state.Request, nil
+	}
+	return nil, ErrSealedStateInvalid
+}
+
+// ReplayCache remembers the ids of the sealed values (see
+// AuthorizeRequest.SealedStateID) already used for an Authorization
+// Response, so a sealed AuthorizeRequest copied from the browser,
+// at any stage, cannot be replayed to get another one.
+//
+// Implementations must be safe for concurrent use.
+type ReplayCache interface {
+
+	// UseOnce atomically records the id. Returns
+	// ErrSealedStateReplayed if it is recorded already.
+	UseOnce(ctx context.Context, id string) error
+}
+
+// MemoryReplayCache is an in-memory ReplayCache implementation.
+//
+// It is safe for concurrent use. As the ids are lost on restart
+// and not shared between processes, it is mainly intended for
+// testing and single instance deployment.
+type MemoryReplayCache struct {
+	mutex sync.Mutex
+	used  map[string]time.Time
+	ttl   time.Duration
+}
+
+// NewMemoryReplayCache returns an initialized *MemoryReplayCache.
+// The ids are kept for ttl, which must not be shorter than the
+// ttl of the StateSealer.
+func NewMemoryReplayCache(ttl time.Duration) *MemoryReplayCache {
+	return &MemoryReplayCache{
+		used: make(map[string]time.Time),
+		ttl:  ttl,
+	}
+}
+
+// UseOnce implements ReplayCache
+func (cache *MemoryReplayCache) UseOnce(ctx context.Context, id string) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	for used, expiresAt := range cache.used {
+		if !now.Before(expiresAt) {
+			delete(cache.used, used)
+		}
+	}
+	if _, ok := cache.used[id]; ok {
+		return ErrSealedStateReplayed
+	}
+	cache.used[id] = now.Add(cache.ttl)
+	return nil
+}
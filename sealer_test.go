@@ -0,0 +1,178 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestAESStateSealer(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 16)
+
+	oldSealer, err := oasis.NewAESStateSealer(time.Minute, oldKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rotatedSealer, _ := oasis.NewAESStateSealer(time.Minute, newKey, oldKey)
+	newSealer, _ := oasis.NewAESStateSealer(time.Minute, newKey)
+
+	ar := &oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		State:        "dummy-state",
+		Stage:        oasis.StageToAuthorize,
+		UserID:       "dummy-user",
+	}
+	sealed, err := oldSealer.Seal(ar)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(sealed, "dummy") {
+		t.Errorf("expected sealed value to be opaque, got %s", sealed)
+	}
+
+	// open with rotated keys
+	opened, err := rotatedSealer.Open(sealed)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if opened.SealedStateID == "" {
+		t.Errorf("expected the id of the sealed value, got none")
+	}
+	if want, have := ar.SealedStateID, opened.SealedStateID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// resealed at another stage with the same id
+	opened.Stage = oasis.StageIntermediate
+	if resealed, _ := oldSealer.Seal(opened); resealed == sealed {
+		t.Errorf("expected a new sealed value, got the same")
+	} else if again, _ := oldSealer.Open(resealed); again.SealedStateID != ar.SealedStateID {
+		t.Errorf("expected %#v, got %#v", ar.SealedStateID, again.SealedStateID)
+	}
+	opened.Stage = ar.Stage
+	if want, have := *ar, *opened; want != have {
+		t.Errorf("\nexpected: %#v\ngot:      %#v", want, have)
+	}
+
+	// open with retired key removed
+	if _, err := newSealer.Open(sealed); err != oasis.ErrSealedStateInvalid {
+		t.Errorf("expected ErrSealedStateInvalid, got %#v", err)
+	}
+
+	// tampered
+	raw, _ := base64.RawURLEncoding.DecodeString(sealed)
+	raw[len(raw)-1] ^= 1
+	if _, err := oldSealer.Open(base64.RawURLEncoding.EncodeToString(raw)); err != oasis.ErrSealedStateInvalid {
+		t.Errorf("expected ErrSealedStateInvalid, got %#v", err)
+	}
+	if _, err := oldSealer.Open("not-sealed"); err != oasis.ErrSealedStateInvalid {
+		t.Errorf("expected ErrSealedStateInvalid, got %#v", err)
+	}
+
+	// expired
+	shortSealer, _ := oasis.NewAESStateSealer(time.Millisecond, oldKey)
+	sealed, _ = shortSealer.Seal(ar)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := shortSealer.Open(sealed); err != oasis.ErrSealedStateExpired {
+		t.Errorf("expected ErrSealedStateExpired, got %#v", err)
+	}
+
+	// invalid key
+	if _, err := oasis.NewAESStateSealer(time.Minute, []byte("short")); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	ctx := context.Background()
+	cache := oasis.NewMemoryReplayCache(time.Millisecond)
+	if err := cache.UseOnce(ctx, "dummy-id"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := cache.UseOnce(ctx, "dummy-id"); err != oasis.ErrSealedStateReplayed {
+		t.Errorf("expected ErrSealedStateReplayed, got %#v", err)
+	}
+	if err := cache.UseOnce(ctx, "other-id"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// forgotten after the sealed value expires
+	time.Sleep(5 * time.Millisecond)
+	if err := cache.UseOnce(ctx, "dummy-id"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestAuthorizeDecoder_StateSealer(t *testing.T) {
+	sealer, _ := oasis.NewAESStateSealer(time.Minute, bytes.Repeat([]byte("k"), 32))
+	actx := &oasis.Context{
+		ClientStore: newTestClientStore(),
+		StateSealer: sealer,
+	}
+	decoder := oasis.NewAuthorizeDecoder("code")
+
+	sealed, _ := sealer.Seal(&oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Stage:        oasis.StageToAuthorize,
+		UserID:       "dummy-user",
+	})
+
+	r, _ := http.NewRequest("GET", "/foobar/authorize", nil)
+	r.AddCookie(&http.Cookie{Name: oasis.SealedStateParam, Value: sealed})
+	r = r.WithContext(oasis.WithContext(r.Context(), actx))
+	_, ar, err := decoder.DecodeAuthorize(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, have := oasis.StageToAuthorize, ar.Stage; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "dummy-user", ar.UserID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "web-client", ar.ClientID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// not accepted in the query
+	r, _ = http.NewRequest("GET", "/foobar/authorize?"+oasis.SealedStateParam+"="+url.QueryEscape(sealed), nil)
+	r = r.WithContext(oasis.WithContext(r.Context(), actx))
+	if _, ar, _ = decoder.DecodeAuthorize(r); ar.UserID != "" {
+		t.Errorf("expected the sealed state in query to be ignored, got %#v", ar.UserID)
+	}
+
+	// ignored by a new authorization request
+	r, _ = http.NewRequest("GET", "/foobar/authorize?response_type=code&client_id=web-client", nil)
+	r.AddCookie(&http.Cookie{Name: oasis.SealedStateParam, Value: sealed})
+	r = r.WithContext(oasis.WithContext(r.Context(), actx))
+	if _, ar, _ = decoder.DecodeAuthorize(r); ar.UserID != "" {
+		t.Errorf("expected the sealed state cookie to be ignored, got %#v", ar.UserID)
+	}
+
+	// tampered
+	r, _ = http.NewRequest("GET", "/foobar/authorize", nil)
+	r.AddCookie(&http.Cookie{Name: oasis.SealedStateParam, Value: "forged"})
+	r = r.WithContext(oasis.WithContext(r.Context(), actx))
+	_, ar, err = decoder.DecodeAuthorize(r)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if want, have := "invalid_request: authorization session is invalid", err.Error(); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if ar == nil {
+		t.Errorf("expected *oasis.AuthorizeRequest, got nil")
+	}
+}
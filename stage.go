@@ -0,0 +1,119 @@
+package oasis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// String implements fmt.Stringer
+func (stage AuthorizeStage) String() string {
+	switch stage {
+	case StageInitialize:
+		return "initialize"
+	case StageToAuthenticate:
+		return "to_authenticate"
+	case StageIntermediate:
+		return "intermediate"
+	case StageToAuthorize:
+		return "to_authorize"
+	case StageCustom:
+		return "custom"
+	}
+	return fmt.Sprintf("AuthorizeStage(%d)", int(stage))
+}
+
+// StageCondition is an entry condition of an authorization stage.
+// It returns an error if the AuthorizeRequest may not enter.
+type StageCondition func(ctx context.Context, ar *AuthorizeRequest) error
+
+// RequireUser is a StageCondition that requires the
+// AuthorizeRequest to have an authenticated user.
+func RequireUser(ctx context.Context, ar *AuthorizeRequest) error {
+	if ar.UserID == "" {
+		return errors.New("user is not authenticated")
+	}
+	return nil
+}
+
+// StageError is returned by AuthorizeHandlerMux when an
+// AuthorizeRequest is rejected by the stage rules.
+//
+// If the request fails the entry condition of a stage, From and
+// To are both the stage. If a handler moves the request to a stage
+// that is not allowed, From and To are the stages before and after
+// the handler.
+//
+// It implements ResponderError.
+type StageError struct {
+	From   AuthorizeStage
+	To     AuthorizeStage
+	Reason error
+}
+
+// Error implements error
+func (err *StageError) Error() string {
+	if err.From == err.To {
+		return fmt.Sprintf("cannot enter stage %s: %s", err.To, err.Reason)
+	}
+	return fmt.Sprintf("illegal stage transition from %s to %s: %s", err.From, err.To, err.Reason)
+}
+
+// Unwrap returns the reason of the error.
+func (err *StageError) Unwrap() error {
+	return err.Reason
+}
+
+// Code implements ResponderError
+func (err *StageError) Code() int {
+	return http.StatusForbidden
+}
+
+// Message implements ResponderError
+func (err *StageError) Message() string {
+	return "The request is not allowed at this stage of authorization."
+}
+
+// stageErrorResponse is a Responder that always returns its
+// error, so the error is displayed by the ResponseEncoder.
+type stageErrorResponse struct {
+	err *StageError
+}
+
+// ResponseTo implements Responder interface
+func (rsp *stageErrorResponse) ResponseTo(w http.ResponseWriter) error {
+	return rsp.err
+}
+
+// errTransitionNotAllowed is the reason of StageError for
+// transitions not declared by AuthorizeHandlerMux.Allow.
+var errTransitionNotAllowed = errors.New("transition is not allowed")
+
+// stageTransitions are the transitions allowed from
+// the stage a handler is called at.
+type stageTransitions struct {
+	from    AuthorizeStage
+	allowed map[AuthorizeStage]bool
+}
+
+// withStageTransitions returns a copy of ctx carrying the
+// transitions allowed from the stage.
+func withStageTransitions(ctx context.Context, from AuthorizeStage, allowed map[AuthorizeStage]bool) context.Context {
+	return context.WithValue(ctx, contextStageTransitions, &stageTransitions{from: from, allowed: allowed})
+}
+
+// CheckStageTransition returns a *StageError if the handler called
+// by AuthorizeHandlerMux may not move the request from the stage
+// to the stage to. Handlers should check it before persisting the
+// transition (as AdvancePending does), so an illegal transition
+// is rejected before anything is saved.
+//
+// It returns nil if ctx is not from AuthorizeHandlerMux.
+func CheckStageTransition(ctx context.Context, from, to AuthorizeStage) error {
+	rules, ok := ctx.Value(contextStageTransitions).(*stageTransitions)
+	if !ok || rules.from != from || rules.allowed == nil || from == to || rules.allowed[to] {
+		return nil
+	}
+	return &StageError{From: from, To: to, Reason: errTransitionNotAllowed}
+}
@@ -0,0 +1,220 @@
+package oasis_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestAuthorizeHandlerMux_stages(t *testing.T) {
+	called := make(map[oasis.AuthorizeStage]bool)
+	moveTo := func(next oasis.AuthorizeStage) oasis.AuthorizeHandlerFunc {
+		return func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+			called[ar.Stage] = true
+			ar.Stage = next
+			return &oasis.ResponseCache{Code: http.StatusOK}
+		}
+	}
+
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.AddFunc(oasis.StageInitialize, moveTo(oasis.StageToAuthenticate))
+	mux.AddFunc(oasis.StageToAuthenticate, moveTo(oasis.StageToAuthorize))
+	mux.AddFunc(oasis.StageIntermediate, moveTo(oasis.StageCustom))
+	mux.AddFunc(oasis.StageToAuthorize, moveTo(oasis.StageToAuthorize))
+	mux.Allow(oasis.StageInitialize, oasis.StageToAuthenticate)
+	mux.Allow(oasis.StageToAuthenticate, oasis.StageIntermediate, oasis.StageToAuthorize)
+	mux.Allow(oasis.StageIntermediate, oasis.StageToAuthorize)
+	mux.Require(oasis.StageIntermediate, oasis.RequireUser)
+	mux.Require(oasis.StageToAuthorize, oasis.RequireUser)
+
+	tests := []struct {
+		desc          string
+		ar            *oasis.AuthorizeRequest
+		expectedCall  bool
+		expectedStage oasis.AuthorizeStage
+		expectedError string
+	}{
+		{
+			desc:          "allowed transition",
+			ar:            &oasis.AuthorizeRequest{Stage: oasis.StageInitialize},
+			expectedCall:  true,
+			expectedStage: oasis.StageToAuthenticate,
+		},
+		{
+			desc:          "forged jump without user",
+			ar:            &oasis.AuthorizeRequest{Stage: oasis.StageToAuthorize},
+			expectedStage: oasis.StageToAuthorize,
+			expectedError: "cannot enter stage to_authorize: user is not authenticated",
+		},
+		{
+			desc:          "authenticated user",
+			ar:            &oasis.AuthorizeRequest{Stage: oasis.StageToAuthorize, UserID: "dummy-user"},
+			expectedCall:  true,
+			expectedStage: oasis.StageToAuthorize,
+		},
+		{
+			desc:          "handler moves to undeclared stage",
+			ar:            &oasis.AuthorizeRequest{Stage: oasis.StageIntermediate, UserID: "dummy-user"},
+			expectedCall:  true,
+			expectedStage: oasis.StageCustom,
+			expectedError: "illegal stage transition from intermediate to custom: transition is not allowed",
+		},
+	}
+
+	for _, test := range tests {
+		called = make(map[oasis.AuthorizeStage]bool)
+		entered := test.ar.Stage
+		rspr := mux.HandleAuthorizeRequest(context.Background(), test.ar, nil)
+
+		if want, have := test.expectedCall, called[entered]; want != have {
+			t.Errorf("%s: expected handler called %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := test.expectedStage, test.ar.Stage; want != have {
+			t.Errorf("%s: expected %s, got %s", test.desc, want, have)
+		}
+
+		err := rspr.ResponseTo(httptest.NewRecorder())
+		if test.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.desc, err)
+			}
+			continue
+		}
+		var stageErr *oasis.StageError
+		if !errors.As(err, &stageErr) {
+			t.Errorf("%s: expected *oasis.StageError, got %#v", test.desc, err)
+			continue
+		}
+		if want, have := test.expectedError, stageErr.Error(); want != have {
+			t.Errorf("%s:\nexpected: %#v\ngot:      %#v", test.desc, want, have)
+		}
+		if want, have := http.StatusForbidden, stageErr.Code(); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+	}
+}
+
+func TestAuthorizeHandlerMux_Use(t *testing.T) {
+	var trace []string
+	wrapped := 0
+	middleware := func(name string) oasis.AuthorizeMiddleware {
+		return func(next oasis.AuthorizeHandler) oasis.AuthorizeHandler {
+			wrapped++
+			return oasis.AuthorizeHandlerFunc(func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+				trace = append(trace, name)
+				return next.HandleAuthorizeRequest(ctx, ar, decodeErr)
+			})
+		}
+	}
+
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Use(middleware("outer"), middleware("inner"))
+	mux.AddFunc(oasis.StageInitialize, func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+		trace = append(trace, "handler")
+		return &oasis.ResponseCache{Code: http.StatusOK}
+	})
+
+	// stage with handler
+	mux.HandleAuthorizeRequest(context.Background(), &oasis.AuthorizeRequest{}, nil)
+	if want, have := "outer,inner,handler", strings.Join(trace, ","); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// the chains are composed once, not on every request
+	composed := wrapped
+	mux.HandleAuthorizeRequest(context.Background(), &oasis.AuthorizeRequest{}, nil)
+	if want, have := composed, wrapped; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// default fallback
+	trace = nil
+	w := httptest.NewRecorder()
+	mux.HandleAuthorizeRequest(context.Background(), &oasis.AuthorizeRequest{Stage: oasis.StageCustom}, nil).ResponseTo(w)
+	if want, have := "outer,inner", strings.Join(trace, ","); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusInternalServerError, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// custom fallback
+	trace = nil
+	mux.SetNotFound(oasis.AuthorizeHandlerFunc(func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+		trace = append(trace, "not found")
+		return &oasis.ResponseCache{Code: http.StatusNotFound}
+	}))
+	w = httptest.NewRecorder()
+	mux.HandleAuthorizeRequest(context.Background(), &oasis.AuthorizeRequest{Stage: oasis.StageCustom}, nil).ResponseTo(w)
+	if want, have := "outer,inner,not found", strings.Join(trace, ","); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := http.StatusNotFound, w.Code; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestAuthorizeHandlerMux_concurrent(t *testing.T) {
+	mux := oasis.NewAuthorizeHandlerMux()
+	handler := oasis.AuthorizeHandlerFunc(func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+		return &oasis.ResponseCache{Code: http.StatusOK}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			mux.Add(oasis.AuthorizeStage(100+i), handler)
+			mux.Allow(oasis.StageInitialize, oasis.AuthorizeStage(100+i))
+			mux.Require(oasis.StageInitialize, func(ctx context.Context, ar *oasis.AuthorizeRequest) error { return nil })
+			mux.Use(func(next oasis.AuthorizeHandler) oasis.AuthorizeHandler { return next })
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			mux.HandleAuthorizeRequest(context.Background(), &oasis.AuthorizeRequest{Stage: oasis.AuthorizeStage(100 + i)}, nil)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestAuthorizeHandlerMux_transitionBeforePersist(t *testing.T) {
+	store := oasis.NewMemoryPendingAuthorizationStore(time.Minute)
+	ctx := oasis.WithContext(context.Background(), &oasis.Context{PendingAuthorizationStore: store})
+	handle, _ := store.CreatePending(ctx, &oasis.AuthorizeRequest{ClientID: "dummy-client"})
+
+	var advanceErr error
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.AddFunc(oasis.StageInitialize, func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+		advanceErr = oasis.AdvancePending(ctx, ar, oasis.StageToAuthorize)
+		return &oasis.ResponseCache{Code: http.StatusOK}
+	})
+	mux.Allow(oasis.StageInitialize, oasis.StageToAuthenticate)
+
+	ar, _ := store.GetPending(ctx, handle)
+	rspr := mux.HandleAuthorizeRequest(ctx, ar, nil)
+
+	var stageErr *oasis.StageError
+	if !errors.As(advanceErr, &stageErr) {
+		t.Fatalf("expected *StageError, got %#v", advanceErr)
+	}
+	if err := rspr.ResponseTo(httptest.NewRecorder()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	stored, _ := store.GetPending(ctx, handle)
+	if want, have := oasis.StageInitialize, stored.Stage; want != have {
+		t.Errorf("expected %s, got %s", want, have)
+	}
+
+	// outside of AuthorizeHandlerMux, nothing is checked
+	if err := oasis.CheckStageTransition(ctx, oasis.StageInitialize, oasis.StageToAuthorize); err != nil {
+		t.Errorf("expected no check outside of mux, got %s", err)
+	}
+}
@@ -0,0 +1,136 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryTokenKey struct {
+	tokenType TokenType
+	value     string
+}
+
+// MemoryTokenStorage is an in-memory TokenStorage implementation.
+//
+// It is safe for concurrent use. Expired tokens are never returned
+// and are removed lazily on access, or by calling Purge.
+//
+// As tokens are lost on restart and not shared between processes,
+// it is mainly intended for testing and single instance deployment.
+type MemoryTokenStorage struct {
+	mutex  sync.Mutex
+	tokens map[memoryTokenKey]*Token
+	now    func() time.Time
+}
+
+// NewMemoryTokenStorage returns an initialized *MemoryTokenStorage
+func NewMemoryTokenStorage() *MemoryTokenStorage {
+	return &MemoryTokenStorage{
+		tokens: make(map[memoryTokenKey]*Token),
+		now:    time.Now,
+	}
+}
+
+// SaveToken implements TokenStorage
+func (store *MemoryTokenStorage) SaveToken(ctx context.Context, token *Token) error {
+	if token == nil {
+		return fmt.Errorf("token is required but not set")
+	}
+	if token.Value == "" {
+		return fmt.Errorf("token value is required but not set")
+	}
+
+	// store a copy so later changes to token
+	// do not affect the stored one
+	stored := *token
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.tokens[memoryTokenKey{token.Type, token.Value}] = &stored
+	return nil
+}
+
+// GetToken implements TokenStorage
+func (store *MemoryTokenStorage) GetToken(ctx context.Context, tokenType TokenType, value string) (*Token, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := memoryTokenKey{tokenType, value}
+	token, ok := store.tokens[key]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	if token.Expired(store.now()) {
+		delete(store.tokens, key)
+		return nil, ErrTokenNotFound
+	}
+	found := *token
+	return &found, nil
+}
+
+// DeleteToken implements TokenStorage
+func (store *MemoryTokenStorage) DeleteToken(ctx context.Context, tokenType TokenType, value string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := memoryTokenKey{tokenType, value}
+	token, ok := store.tokens[key]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	delete(store.tokens, key)
+	if token.Expired(store.now()) {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// GetTokensByClient implements TokenStorage
+func (store *MemoryTokenStorage) GetTokensByClient(ctx context.Context, clientID string) ([]*Token, error) {
+	return store.filter(func(token *Token) bool {
+		return token.ClientID == clientID
+	}), nil
+}
+
+// GetTokensByUser implements TokenStorage
+func (store *MemoryTokenStorage) GetTokensByUser(ctx context.Context, userID string) ([]*Token, error) {
+	return store.filter(func(token *Token) bool {
+		return token.UserID == userID
+	}), nil
+}
+
+// Purge removes all expired tokens from the storage.
+func (store *MemoryTokenStorage) Purge() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.now()
+	for key, token := range store.tokens {
+		if token.Expired(now) {
+			delete(store.tokens, key)
+		}
+	}
+}
+
+// filter returns copies of all unexpired tokens that match,
+// ordered by their issue time.
+func (store *MemoryTokenStorage) filter(match func(*Token) bool) (tokens []*Token) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.now()
+	for _, token := range store.tokens {
+		if token.Expired(now) || !match(token) {
+			continue
+		}
+		found := *token
+		tokens = append(tokens, &found)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].IssuedAt.Before(tokens[j].IssuedAt)
+	})
+	return
+}
@@ -0,0 +1,128 @@
+package oasis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestMemoryTokenStorage(t *testing.T) {
+	var store oasis.TokenStorage = oasis.NewMemoryTokenStorage()
+	ctx := context.Background()
+	now := time.Now()
+
+	tokens := []*oasis.Token{
+		{
+			Type:      oasis.TokenTypeAccessToken,
+			Value:     "token-1",
+			ClientID:  "client-1",
+			UserID:    "user-1",
+			IssuedAt:  now,
+			ExpiresAt: now.Add(time.Hour),
+		},
+		{
+			Type:      oasis.TokenTypeRefreshToken,
+			Value:     "token-2",
+			ClientID:  "client-1",
+			UserID:    "user-2",
+			IssuedAt:  now.Add(time.Second),
+			ExpiresAt: now.Add(time.Hour),
+		},
+		{
+			Type:      oasis.TokenTypeAccessToken,
+			Value:     "token-3",
+			ClientID:  "client-1",
+			UserID:    "user-1",
+			IssuedAt:  now.Add(-2 * time.Hour),
+			ExpiresAt: now.Add(-time.Hour),
+		},
+	}
+	for _, token := range tokens {
+		if err := store.SaveToken(ctx, token); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// lookup by value
+	token, err := store.GetToken(ctx, oasis.TokenTypeAccessToken, "token-1")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if want, have := "user-1", token.UserID; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// lookup with wrong type
+	if _, err := store.GetToken(ctx, oasis.TokenTypeAccessToken, "token-2"); err != oasis.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound, got %#v", err)
+	}
+
+	// lookup expired
+	if _, err := store.GetToken(ctx, oasis.TokenTypeAccessToken, "token-3"); err != oasis.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound, got %#v", err)
+	}
+
+	// lookup by client
+	found, _ := store.GetTokensByClient(ctx, "client-1")
+	if want, have := 2, len(found); want != have {
+		t.Fatalf("expected %d tokens, got %d", want, have)
+	}
+	if want, have := "token-1", found[0].Value; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "token-2", found[1].Value; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// lookup by user
+	found, _ = store.GetTokensByUser(ctx, "user-2")
+	if want, have := 1, len(found); want != have {
+		t.Fatalf("expected %d tokens, got %d", want, have)
+	}
+	if want, have := "token-2", found[0].Value; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// delete
+	if err := store.DeleteToken(ctx, oasis.TokenTypeAccessToken, "token-1"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := store.DeleteToken(ctx, oasis.TokenTypeAccessToken, "token-1"); err != oasis.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound, got %#v", err)
+	}
+	if _, err := store.GetToken(ctx, oasis.TokenTypeAccessToken, "token-1"); err != oasis.ErrTokenNotFound {
+		t.Errorf("expected ErrTokenNotFound, got %#v", err)
+	}
+}
+
+func TestMemoryTokenStorage_concurrentDelete(t *testing.T) {
+	store := oasis.NewMemoryTokenStorage()
+	ctx := context.Background()
+	store.SaveToken(ctx, &oasis.Token{
+		Type:      oasis.TokenTypeAuthorizationCode,
+		Value:     "code-1",
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	success := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.DeleteToken(ctx, oasis.TokenTypeAuthorizationCode, "code-1"); err == nil {
+				mutex.Lock()
+				success++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if want, have := 1, success; want != have {
+		t.Errorf("expected %d successful delete, got %d", want, have)
+	}
+}
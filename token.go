@@ -0,0 +1,158 @@
+package oasis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// TokenType represents the kind of a Token.
+type TokenType int
+
+const (
+	// TokenTypeAuthorizationCode represents an Authorization
+	// Code as described in RFC6749 section 1.3.1.
+	TokenTypeAuthorizationCode TokenType = iota
+
+	// TokenTypeAccessToken represents an Access Token
+	// as described in RFC6749 section 1.4.
+	TokenTypeAccessToken
+
+	// TokenTypeRefreshToken represents a Refresh Token
+	// as described in RFC6749 section 1.5.
+	TokenTypeRefreshToken
+)
+
+// String implements fmt.Stringer
+func (tokenType TokenType) String() string {
+	switch tokenType {
+	case TokenTypeAuthorizationCode:
+		return "authorization_code"
+	case TokenTypeAccessToken:
+		return "access_token"
+	case TokenTypeRefreshToken:
+		return "refresh_token"
+	}
+	return fmt.Sprintf("TokenType(%d)", int(tokenType))
+}
+
+// Token represents a token produced by TokenFactory.
+type Token struct {
+
+	// Type of the token.
+	Type TokenType `json:"type"`
+
+	// Value is the token string to be handed to the client.
+	Value string `json:"value"`
+
+	// ClientID of the client this token is issued to.
+	ClientID string `json:"client_id"`
+
+	// UserID of the resource owner who authorized
+	// the token, if any.
+	UserID string `json:"user_id,omitempty"`
+
+	// Scope of access granted to this token.
+	Scope string `json:"scope,omitempty"`
+
+	// RedirectURI is the redirect_uri of the authorization
+	// request an Authorization Code is issued for, if any.
+	RedirectURI string `json:"redirect_uri,omitempty"`
+
+	// RedirectURIDefaulted marks that the redirect_uri is not in
+	// the authorization request, but filled in from the client
+	// registration. The token request may then omit it.
+	RedirectURIDefaulted bool `json:"redirect_uri_defaulted,omitempty"`
+
+	// CodeChallenge and CodeChallengeMethod are the PKCE
+	// parameters of the authorization request an Authorization
+	// Code is issued for, if any.
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+
+	// Nonce and AuthTime are the nonce and the time of user
+	// authentication of the OpenID Connect request an
+	// Authorization Code is issued for, if any.
+	Nonce    string     `json:"nonce,omitempty"`
+	AuthTime *time.Time `json:"auth_time,omitempty"`
+
+	// IssuedAt is the time the token is produced.
+	IssuedAt time.Time `json:"issued_at"`
+
+	// ExpiresAt is the time the token expires.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the token has expired
+// at the given time.
+func (token *Token) Expired(now time.Time) bool {
+	return !token.ExpiresAt.IsZero() && !now.Before(token.ExpiresAt)
+}
+
+// DefaultTokenLength is the default number of random
+// bytes in every token produced by DefaultTokenFactory.
+const DefaultTokenLength = 32
+
+// DefaultTokenFactory is the default TokenFactory implementation.
+//
+// It produces opaque tokens from crypto/rand. The token
+// strings carry no information by themselves. They are
+// only meaningful when looked up in a TokenStorage.
+type DefaultTokenFactory struct {
+	length int
+	encode func([]byte) string
+}
+
+// NewToken implements TokenFactory.
+func (tf *DefaultTokenFactory) NewToken(ctx context.Context, tokenType TokenType, ar *AuthorizeRequest, expiresIn time.Duration) (token *Token, err error) {
+	if ar == nil {
+		err = fmt.Errorf("authorize request is required but not set")
+		return
+	}
+	if expiresIn <= 0 {
+		err = fmt.Errorf("token lifetime must be positive, got %s", expiresIn)
+		return
+	}
+
+	buf := make([]byte, tf.length)
+	if _, err = rand.Read(buf); err != nil {
+		err = fmt.Errorf("unable to read random bytes. %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	token = &Token{
+		Type:      tokenType,
+		Value:     tf.encode(buf),
+		ClientID:  ar.ClientID,
+		UserID:    ar.UserID,
+		Scope:     ar.Scope,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(expiresIn),
+	}
+	return
+}
+
+// NewTokenFactory returns the default TokenFactory implementation
+// which:
+//
+// 1. reads length bytes from crypto/rand for every token,
+// 2. encodes the bytes into token string with encode.
+//
+// If length is not positive, DefaultTokenLength is used. Length
+// below 16 (i.e. 128 bits) is not recommended. If encode is nil,
+// unpadded base64url encoding (RFC4648 section 5) is used.
+func NewTokenFactory(length int, encode func([]byte) string) TokenFactory {
+	if length <= 0 {
+		length = DefaultTokenLength
+	}
+	if encode == nil {
+		encode = base64.RawURLEncoding.EncodeToString
+	}
+	return &DefaultTokenFactory{
+		length: length,
+		encode: encode,
+	}
+}
@@ -0,0 +1,356 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Grant types of token request as described in RFC6749.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+
+	// GrantTypeImplicit is not a grant_type of token request, but
+	// the value of the implicit grant (RFC6749 section 4.2) in the
+	// metadata and client registration (RFC7591 section 2).
+	GrantTypeImplicit = "implicit"
+)
+
+// TokenRequest represents an Access Token Request to the token
+// endpoint (as described in RFC6749 section 4.1.3, 4.4.2 and 6).
+//
+// If the TokenRequest is generated from http.Request, it should
+// be attached to attribute HTTPRequest.
+type TokenRequest struct {
+
+	// HTTPRequest is the raw http.Request that this
+	// TokenRequest is constructed from, if any.
+	HTTPRequest *http.Request `json:"-"`
+
+	// GrantType. REQUIRED. The grant type of the request
+	// (e.g. "authorization_code" or "refresh_token").
+	GrantType string `json:"grant_type"`
+
+	// Code. The authorization code received from the
+	// authorization server. REQUIRED for "authorization_code"
+	// grant type.
+	Code string `json:"code,omitempty"`
+
+	// RedirectURI. The redirect_uri included in the authorization
+	// request, if any. REQUIRED for "authorization_code" grant type
+	// if it was included in the authorization request.
+	RedirectURI string `json:"redirect_uri,omitempty"`
+
+	// CodeVerifier. The PKCE code verifier as described in
+	// RFC7636 section 4.5. REQUIRED for "authorization_code"
+	// grant type if code_challenge was included in the
+	// authorization request.
+	CodeVerifier string `json:"-"`
+
+	// RefreshToken. The refresh token issued to the client.
+	// REQUIRED for "refresh_token" grant type.
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// Scope. OPTIONAL. The scope of access request as described by
+	// RFC6749 section 3.3
+	Scope string `json:"scope,omitempty"`
+
+	// ClientID is the client identifier either from HTTP Basic
+	// authentication or from the request body.
+	ClientID string `json:"client_id,omitempty"`
+
+	// ClientSecret is the client password either from HTTP Basic
+	// authentication or from the request body, if any.
+	ClientSecret string `json:"-"`
+}
+
+// TokenDecoder decodes an http request as
+// a TokenRequest.
+type TokenDecoder interface {
+	DecodeToken(*http.Request) (context.Context, *TokenRequest, error)
+}
+
+// DefaultTokenDecoder is the default TokenDecoder implementation.
+type DefaultTokenDecoder struct {
+	allowedGrantTypes map[string]bool
+}
+
+// DecodeToken implements TokenDecoder.
+//
+// It also validates the decoded TokenRequest to the RFC6749
+// standard. The error returned, if any, is an *Error.
+//
+// An *TokenRequest is always returned even if
+// there is an error.
+func (td *DefaultTokenDecoder) DecodeToken(r *http.Request) (ctx context.Context, tr *TokenRequest, err error) {
+
+	// inherit the context from request
+	ctx = r.Context()
+	tr = &TokenRequest{HTTPRequest: r}
+
+	// RFC6749 section 3.2: the client MUST use the HTTP "POST"
+	// method with "application/x-www-form-urlencoded" format
+	if r.Method != http.MethodPost {
+		err = &Error{
+			ErrorCode:   ErrInvalidRequest,
+			Description: "token request must use POST method",
+			StatusCode:  http.StatusMethodNotAllowed,
+		}
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		err = NewError(ErrInvalidRequest, "token request must be application/x-www-form-urlencoded")
+		return
+	}
+	if parseErr := r.ParseForm(); parseErr != nil {
+		err = NewError(ErrInvalidRequest, "unable to parse request body")
+		return
+	}
+
+	// RFC6749 section 3.2: parameters MUST NOT be
+	// included more than once.
+	for key, values := range r.PostForm {
+		if len(values) > 1 {
+			err = NewError(ErrInvalidRequest, fmt.Sprintf("parameter %s is included more than once", key))
+			return
+		}
+	}
+
+	form := r.PostForm
+	tr.GrantType = strings.Trim(form.Get("grant_type"), "\r\n\t ")
+	tr.Code = strings.Trim(form.Get("code"), "\r\n\t ")
+	tr.RedirectURI = strings.Trim(form.Get("redirect_uri"), "\r\n\t ")
+	tr.CodeVerifier = strings.Trim(form.Get("code_verifier"), "\r\n\t ")
+	tr.RefreshToken = strings.Trim(form.Get("refresh_token"), "\r\n\t ")
+	tr.Scope = strings.Trim(form.Get("scope"), "\r\n\t ")
+	tr.ClientID = strings.Trim(form.Get("client_id"), "\r\n\t ")
+	tr.ClientSecret = form.Get("client_secret")
+
+	// RFC6749 section 2.3.1: client credentials in HTTP Basic
+	// authentication are encoded with form-urlencoded first.
+	if username, password, ok := r.BasicAuth(); ok {
+		if form.Get("client_secret") != "" {
+			err = NewError(ErrInvalidRequest, "client must not use more than one authentication method")
+			return
+		}
+		clientID, idErr := url.QueryUnescape(username)
+		clientSecret, secretErr := url.QueryUnescape(password)
+		if idErr != nil || secretErr != nil {
+			err = NewError(ErrInvalidClient, "malformed client credentials")
+			return
+		}
+		if tr.ClientID != "" && tr.ClientID != clientID {
+			err = NewError(ErrInvalidRequest, "client_id does not match the authenticated client")
+			return
+		}
+		tr.ClientID, tr.ClientSecret = clientID, clientSecret
+	}
+
+	if tr.GrantType == "" {
+		err = NewError(ErrInvalidRequest, "grant_type is required but not set")
+		return
+	}
+	if _, ok := td.allowedGrantTypes[tr.GrantType]; !ok {
+		err = NewError(ErrUnsupportedGrantType, fmt.Sprintf(`grant_type "%s" is not supported`, tr.GrantType))
+	}
+	return
+}
+
+// GrantTypes returns the allowed grant_type values, sorted.
+func (td *DefaultTokenDecoder) GrantTypes() []string {
+	return sortedKeys(td.allowedGrantTypes)
+}
+
+// NewTokenDecoder returns the default TokenDecoder implementation
+// which:
+//
+// 1. limits grant_type to the allowedGrantTypes,
+// 2. use the http.Request's context (i.e. `r.Context()` as context return).
+func NewTokenDecoder(allowedGrantTypes ...string) TokenDecoder {
+	allowedGrantTypesMap := make(map[string]bool)
+	for _, grantType := range allowedGrantTypes {
+		allowedGrantTypesMap[grantType] = true
+	}
+	return &DefaultTokenDecoder{
+		allowedGrantTypes: allowedGrantTypesMap,
+	}
+}
+
+// TokenHandler handles the Access Token Request.
+//
+// In RFC, the response is either a successful JSON
+// response (RFC6749 section 5.1, i.e. TokenResponse in
+// this library) or a JSON Error Response (RFC6749 section
+// 5.2, i.e. ErrorResponse in this library).
+type TokenHandler interface {
+	HandleTokenRequest(
+		ctx context.Context,
+		tr *TokenRequest,
+		decodeErr error,
+	) (rd Responder)
+}
+
+// TokenHandlerFunc is an adaptor to allow the use of ordinary
+// functions as TokenHandler.
+type TokenHandlerFunc func(
+	ctx context.Context,
+	tr *TokenRequest,
+	decodeErr error,
+) (rd Responder)
+
+// HandleTokenRequest implements TokenHandler
+func (f TokenHandlerFunc) HandleTokenRequest(ctx context.Context, tr *TokenRequest, decodeErr error) Responder {
+	return f(ctx, tr, decodeErr)
+}
+
+// TokenHandlerMux route different grant type of TokenRequest
+// to different TokenHandler.
+//
+// Requests failed to decode are responded with the decode
+// error directly, without calling any handler.
+type TokenHandlerMux struct {
+	handlers map[string]TokenHandler
+}
+
+// NewTokenHandlerMux returns an initialized *TokenHandlerMux
+func NewTokenHandlerMux() *TokenHandlerMux {
+	return &TokenHandlerMux{
+		handlers: make(map[string]TokenHandler),
+	}
+}
+
+// Add a handler to handle specific grant type.
+//
+// If 2 handlers are added to the same grant type, the later
+// one will overwrite the former one.
+func (mux *TokenHandlerMux) Add(grantType string, handler TokenHandler) {
+	mux.handlers[grantType] = handler
+}
+
+// AddFunc add a function, as handler, to handle specific grant type.
+//
+// If 2 handlers are added to the same grant type, the later
+// one will overwrite the former one.
+func (mux *TokenHandlerMux) AddFunc(grantType string, handler TokenHandlerFunc) {
+	mux.handlers[grantType] = handler
+}
+
+// HandleTokenRequest implements TokenHandler
+func (mux *TokenHandlerMux) HandleTokenRequest(ctx context.Context, tr *TokenRequest, decodeErr error) (rd Responder) {
+	if decodeErr != nil {
+		return NewTokenErrorResponse(decodeErr)
+	}
+
+	if handler, ok := mux.handlers[tr.GrantType]; ok {
+		return handler.HandleTokenRequest(ctx, tr, decodeErr)
+	}
+	return NewTokenErrorResponse(NewError(
+		ErrUnsupportedGrantType,
+		fmt.Sprintf(`grant_type "%s" is not supported`, tr.GrantType),
+	))
+}
+
+// NewTokenErrorResponse returns an *ErrorResponse for the error.
+//
+// If err is not an *Error, it is reported as "invalid_request"
+// with its message as the description. An "invalid_client" error
+// is sent with the WWW-Authenticate header (RFC6749 section 5.2).
+func NewTokenErrorResponse(err error) *ErrorResponse {
+	oerr, ok := err.(*Error)
+	if !ok {
+		oerr = NewError(ErrInvalidRequest, err.Error())
+	}
+	header := make(http.Header)
+	if oerr.Code() == http.StatusUnauthorized {
+		header.Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+	return &ErrorResponse{
+		HeaderCache: header,
+		Err:         oerr,
+	}
+}
+
+// TokenResponse is the successful response of token endpoint
+// as described in RFC6749 section 5.1.
+type TokenResponse struct {
+
+	// HeaderCache stores the response http header
+	HeaderCache http.Header `json:"-"`
+
+	// AccessToken. REQUIRED. The access token issued.
+	AccessToken string `json:"access_token"`
+
+	// TokenType. REQUIRED. The type of the token issued
+	// as described in RFC6749 section 7.1.
+	TokenType string `json:"token_type"`
+
+	// ExpiresIn. RECOMMENDED. The lifetime in seconds
+	// of the access token.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+
+	// RefreshToken. OPTIONAL. The refresh token.
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// Scope. OPTIONAL if identical to the scope requested
+	// by the client; otherwise, REQUIRED.
+	Scope string `json:"scope,omitempty"`
+
+	// IDToken. The ID Token, if the openid scope is granted
+	// (OpenID Connect Core 1.0 section 3.1.3.3).
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// NewTokenResponse returns a bearer *TokenResponse for the
+// access token and, optionally, the refresh token.
+func NewTokenResponse(accessToken, refreshToken *Token) *TokenResponse {
+	rsp := &TokenResponse{
+		HeaderCache: make(http.Header),
+		AccessToken: accessToken.Value,
+		TokenType:   "Bearer",
+		Scope:       accessToken.Scope,
+	}
+	if !accessToken.ExpiresAt.IsZero() {
+		rsp.ExpiresIn = int64(time.Until(accessToken.ExpiresAt).Round(time.Second) / time.Second)
+	}
+	if refreshToken != nil {
+		rsp.RefreshToken = refreshToken.Value
+	}
+	return rsp
+}
+
+// ResponseTo implements Responder interface
+func (tr *TokenResponse) ResponseTo(w http.ResponseWriter) error {
+	for key, values := range tr.HeaderCache {
+		for i := range values {
+			w.Header().Add(key, values[i])
+		}
+	}
+	return writeJSON(w, http.StatusOK, tr)
+}
+
+// NewTokenEndpoint returns an http.Handler
+// to handle the token endpoint.
+func NewTokenEndpoint(
+	actx Context,
+	decoder TokenDecoder,
+	handler TokenHandler,
+	encoder ResponseEncoder,
+) http.Handler {
+	actx = actx.withDefaults()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithContext(r.Context(), &actx)
+		ctx, tr, decodeErr := decoder.DecodeToken(r.WithContext(ctx))
+		rspr := handler.HandleTokenRequest(ctx, tr, decodeErr)
+		encodeAndLog(ctx, actx.Logger, "token request", w, r, encoder, rspr, decodeErr,
+			slog.String("client_id", tr.ClientID),
+			slog.String("grant_type", tr.GrantType),
+		)
+	})
+}
@@ -0,0 +1,246 @@
+package oasis_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestTokenDecoder_DecodeToken(t *testing.T) {
+	decoder := oasis.NewTokenDecoder(oasis.GrantTypeAuthorizationCode)
+
+	tests := []struct {
+		desc          string
+		method        string
+		contentType   string
+		form          url.Values
+		username      string
+		password      string
+		expected      *oasis.TokenRequest
+		expectedError string
+	}{
+		{
+			desc:        "client_secret_post",
+			method:      "POST",
+			contentType: "application/x-www-form-urlencoded",
+			form: url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {"dummy-code"},
+				"redirect_uri":  {"https://client.foobar.com/cb"},
+				"client_id":     {"dummy-client"},
+				"client_secret": {"dummy-secret"},
+			},
+			expected: &oasis.TokenRequest{
+				GrantType:    "authorization_code",
+				Code:         "dummy-code",
+				RedirectURI:  "https://client.foobar.com/cb",
+				ClientID:     "dummy-client",
+				ClientSecret: "dummy-secret",
+			},
+		},
+		{
+			desc:        "client_secret_basic",
+			method:      "POST",
+			contentType: "application/x-www-form-urlencoded",
+			form: url.Values{
+				"grant_type": {"authorization_code"},
+				"code":       {"dummy-code"},
+			},
+			username: "dummy%3Aclient",
+			password: "dummy-secret",
+			expected: &oasis.TokenRequest{
+				GrantType:    "authorization_code",
+				Code:         "dummy-code",
+				ClientID:     "dummy:client",
+				ClientSecret: "dummy-secret",
+			},
+		},
+		{
+			desc:          "GET method",
+			method:        "GET",
+			form:          url.Values{"grant_type": {"authorization_code"}},
+			expectedError: "invalid_request: token request must use POST method",
+		},
+		{
+			desc:          "JSON body",
+			method:        "POST",
+			contentType:   "application/json",
+			expectedError: "invalid_request: token request must be application/x-www-form-urlencoded",
+		},
+		{
+			desc:        "both authentication methods",
+			method:      "POST",
+			contentType: "application/x-www-form-urlencoded",
+			form: url.Values{
+				"grant_type":    {"authorization_code"},
+				"client_secret": {"dummy-secret"},
+			},
+			username:      "dummy-client",
+			password:      "dummy-secret",
+			expectedError: "invalid_request: client must not use more than one authentication method",
+		},
+		{
+			desc:          "no grant_type",
+			method:        "POST",
+			contentType:   "application/x-www-form-urlencoded",
+			form:          url.Values{"code": {"dummy-code"}},
+			expectedError: "invalid_request: grant_type is required but not set",
+		},
+		{
+			desc:          "unsupported grant_type",
+			method:        "POST",
+			contentType:   "application/x-www-form-urlencoded",
+			form:          url.Values{"grant_type": {"password"}},
+			expectedError: `unsupported_grant_type: grant_type "password" is not supported`,
+		},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, "/foobar/token", strings.NewReader(test.form.Encode()))
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+
+		_, tr, err := decoder.DecodeToken(r)
+		if tr == nil {
+			t.Errorf("%s: expected *oasis.TokenRequest, got nil", test.desc)
+			continue
+		}
+		if test.expected != nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.desc, err)
+			}
+			tr.HTTPRequest = nil // no need to test the raw request
+			if want, have := *test.expected, *tr; want != have {
+				t.Errorf("%s:\nexpected: %#v\ngot:      %#v", test.desc, want, have)
+			}
+		}
+		if test.expectedError != "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got nil", test.desc)
+			} else if want, have := test.expectedError, err.Error(); want != have {
+				t.Errorf("%s:\nexpected: %#v\ngot:      %#v", test.desc, want, have)
+			}
+		}
+	}
+}
+
+func TestNewTokenEndpoint(t *testing.T) {
+	mux := oasis.NewTokenHandlerMux()
+	mux.AddFunc(oasis.GrantTypeClientCredentials, func(
+		ctx context.Context,
+		tr *oasis.TokenRequest,
+		decodeErr error,
+	) oasis.Responder {
+		if tr.ClientSecret != "dummy-secret" {
+			return oasis.NewTokenErrorResponse(oasis.NewError(oasis.ErrInvalidClient, "client authentication failed"))
+		}
+		return oasis.NewTokenResponse(&oasis.Token{
+			Value:     "dummy-token",
+			Scope:     "read",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}, nil)
+	})
+
+	handler := oasis.NewTokenEndpoint(
+		oasis.Context{},
+		oasis.NewTokenDecoder(oasis.GrantTypeClientCredentials, oasis.GrantTypeRefreshToken),
+		mux,
+		oasis.NewResponseEncoder(),
+	)
+
+	tests := []struct {
+		desc         string
+		form         url.Values
+		expectedCode int
+		expected     map[string]interface{}
+	}{
+		{
+			desc: "success",
+			form: url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {"dummy-client"},
+				"client_secret": {"dummy-secret"},
+			},
+			expectedCode: http.StatusOK,
+			expected: map[string]interface{}{
+				"access_token": "dummy-token",
+				"token_type":   "Bearer",
+				"expires_in":   float64(3600),
+				"scope":        "read",
+			},
+		},
+		{
+			desc: "handler error",
+			form: url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {"dummy-client"},
+				"client_secret": {"wrong-secret"},
+			},
+			expectedCode: http.StatusUnauthorized,
+			expected: map[string]interface{}{
+				"error":             "invalid_client",
+				"error_description": "client authentication failed",
+			},
+		},
+		{
+			desc:         "decode error",
+			form:         url.Values{"grant_type": {"authorization_code"}},
+			expectedCode: http.StatusBadRequest,
+			expected: map[string]interface{}{
+				"error":             "unsupported_grant_type",
+				"error_description": `grant_type "authorization_code" is not supported`,
+			},
+		},
+		{
+			desc:         "no handler for grant type",
+			form:         url.Values{"grant_type": {"refresh_token"}},
+			expectedCode: http.StatusBadRequest,
+			expected: map[string]interface{}{
+				"error":             "unsupported_grant_type",
+				"error_description": `grant_type "refresh_token" is not supported`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "https://foobar.com/token", strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if want, have := test.expectedCode, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := "application/json;charset=UTF-8", w.Header().Get("Content-Type"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := "no-store", w.Header().Get("Cache-Control"); want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+			continue
+		}
+		if want, have := len(test.expected), len(body); want != have {
+			t.Errorf("%s: expected %d fields, got %#v", test.desc, want, body)
+		}
+		for key, expected := range test.expected {
+			if want, have := expected, body[key]; want != have {
+				t.Errorf("%s: %s: expected %#v, got %#v", test.desc, key, want, have)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package oasis_test
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestTokenFactory(t *testing.T) {
+	tests := []struct {
+		desc           string
+		factory        oasis.TokenFactory
+		expectedLength int
+	}{
+		{
+			desc:           "default settings",
+			factory:        oasis.NewTokenFactory(0, nil),
+			expectedLength: 43, // 32 bytes in unpadded base64url
+		},
+		{
+			desc:           "16 bytes in hex",
+			factory:        oasis.NewTokenFactory(16, hex.EncodeToString),
+			expectedLength: 32,
+		},
+	}
+
+	ar := &oasis.AuthorizeRequest{
+		ClientID: "dummy-client",
+		UserID:   "dummy-user",
+		Scope:    "read write",
+	}
+
+	for _, test := range tests {
+		token1, err := test.factory.NewToken(context.Background(), oasis.TokenTypeAccessToken, ar, time.Hour)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.desc, err)
+			continue
+		}
+		token2, _ := test.factory.NewToken(context.Background(), oasis.TokenTypeAccessToken, ar, time.Hour)
+
+		if want, have := test.expectedLength, len(token1.Value); want != have {
+			t.Errorf("%s: expected length %d, got %d", test.desc, want, have)
+		}
+		if token1.Value == token2.Value {
+			t.Errorf("%s: expected different token values, got %#v twice", test.desc, token1.Value)
+		}
+		if want, have := oasis.TokenTypeAccessToken, token1.Type; want != have {
+			t.Errorf("%s: expected %s, got %s", test.desc, want, have)
+		}
+		if want, have := "dummy-client", token1.ClientID; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := "dummy-user", token1.UserID; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := "read write", token1.Scope; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.desc, want, have)
+		}
+		if want, have := time.Hour, token1.ExpiresAt.Sub(token1.IssuedAt); want != have {
+			t.Errorf("%s: expected %s, got %s", test.desc, want, have)
+		}
+		if token1.Expired(time.Now()) {
+			t.Errorf("%s: expected token not expired", test.desc)
+		}
+		if !token1.Expired(token1.ExpiresAt) {
+			t.Errorf("%s: expected token expired at ExpiresAt", test.desc)
+		}
+	}
+}
+
+func TestTokenFactory_nilRequest(t *testing.T) {
+	_, err := oasis.NewTokenFactory(0, nil).NewToken(context.Background(), oasis.TokenTypeAccessToken, nil, time.Hour)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestTokenFactory_invalidTTL(t *testing.T) {
+	factory := oasis.NewTokenFactory(0, nil)
+	for _, expiresIn := range []time.Duration{0, -time.Minute} {
+		_, err := factory.NewToken(context.Background(), oasis.TokenTypeAccessToken, &oasis.AuthorizeRequest{ClientID: "dummy-client"}, expiresIn)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", expiresIn)
+		}
+	}
+}
@@ -0,0 +1,702 @@
+package oasis
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTPCodeParam is the name of the form field of the one-time
+// password, or of a recovery code.
+const OTPCodeParam = "otp"
+
+// Defaults of TOTPHandler.
+const (
+	// DefaultTOTPDigits is the number of digits of a code.
+	DefaultTOTPDigits = 6
+
+	// DefaultTOTPPeriod is the time step of the codes
+	// (RFC6238 section 5.2).
+	DefaultTOTPPeriod = 30 * time.Second
+
+	// DefaultTOTPSkew is the number of time steps before and
+	// after the current one that a code is still accepted.
+	DefaultTOTPSkew = 1
+
+	// DefaultRecoveryCodes is the number of recovery
+	// codes generated on confirmation of the enrollment.
+	DefaultRecoveryCodes = 10
+
+	// DefaultTOTPMaxAttempts is the number of wrong codes
+	// allowed for an authorization.
+	DefaultTOTPMaxAttempts = 5
+)
+
+// Errors returned by OTPSecretStore.
+var (
+	// ErrOTPSecretNotFound is returned when the
+	// user has no OTP secret enrolled.
+	ErrOTPSecretNotFound = errors.New("otp secret not found")
+
+	// ErrOTPCodeUsed is returned when the time step of a code
+	// is not later than the one last used by the user.
+	ErrOTPCodeUsed = errors.New("otp code has already been used")
+
+	// ErrRecoveryCodeInvalid is returned when the recovery
+	// code does not exist or has been used.
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid")
+)
+
+// OTPSecret is the TOTP secret of a user.
+type OTPSecret struct {
+
+	// Secret is the shared secret key (RFC6238 section 5.1).
+	Secret []byte
+
+	// Confirmed is true once the user has entered a valid code
+	// with the secret. TOTPHandler only saves confirmed secrets,
+	// and ignores unconfirmed ones.
+	Confirmed bool
+
+	// LastCounter is the time step of the last accepted code.
+	LastCounter uint64
+
+	// RecoveryCodeHashes are the keyed hashes (see HashRecoveryCode)
+	// of the unused recovery codes.
+	RecoveryCodeHashes []string
+}
+
+// OTPSecretStore stores the TOTP secrets of users.
+//
+// Implementations must be safe for concurrent use.
+type OTPSecretStore interface {
+
+	// GetOTPSecret returns the OTPSecret of the user, or
+	// ErrOTPSecretNotFound if there is none.
+	GetOTPSecret(ctx context.Context, userID string) (*OTPSecret, error)
+
+	// SaveOTPSecret creates or replaces the OTPSecret of the user.
+	SaveOTPSecret(ctx context.Context, userID string, secret *OTPSecret) error
+
+	// UseOTPCounter atomically sets the LastCounter of the user to
+	// counter, only if counter is greater than it. Returns
+	// ErrOTPCodeUsed if not.
+	UseOTPCounter(ctx context.Context, userID string, counter uint64) error
+
+	// UseRecoveryCode atomically removes the recovery code hash
+	// of the user. Returns ErrRecoveryCodeInvalid if there is
+	// no such hash.
+	UseRecoveryCode(ctx context.Context, userID, hash string) error
+}
+
+// GenerateTOTP returns the TOTP value of the secret at time t, as
+// described in RFC6238 with HMAC-SHA-1.
+func GenerateTOTP(secret []byte, t time.Time, digits int, period time.Duration) string {
+	return generateHOTP(secret, totpCounter(t, period), digits)
+}
+
+// totpCounter returns the time step of t.
+func totpCounter(t time.Time, period time.Duration) uint64 {
+	return uint64(t.Unix() / int64(period/time.Second))
+}
+
+// generateHOTP returns the HOTP value of the counter,
+// as described in RFC4226 section 5.3.
+func generateHOTP(secret []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+// OTPAuthURI returns the otpauth:// URI of the secret, to be
+// added to authenticator apps (e.g. as a QR code).
+func OTPAuthURI(issuer, account string, secret []byte, digits int, period time.Duration) string {
+	query := url.Values{
+		"secret":    {encodeOTPSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(digits)},
+		"period":    {strconv.Itoa(int(period / time.Second))},
+	}
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// encodeOTPSecret encodes the secret in unpadded base32,
+// the format authenticator apps expect.
+func encodeOTPSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// decodeOTPSecret decodes the secret encoded by encodeOTPSecret.
+func decodeOTPSecret(encoded string) ([]byte, error) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil || len(secret) == 0 {
+		return nil, fmt.Errorf("otp secret is misformed")
+	}
+	return secret, nil
+}
+
+// HashRecoveryCode returns the hash of the recovery code of the user
+// to be stored, i.e. the HMAC-SHA-256 keyed with the server secret key
+// of the user id and the code. A leaked store alone is not enough to
+// recover the codes, and the same code hashes differently for each
+// user. The code is normalized first, so dashes, spaces and letter
+// case do not matter.
+func HashRecoveryCode(key []byte, userID, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newRecoveryCodes returns n random recovery codes.
+func newRecoveryCodes(n int) ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, n)
+	buf := make([]byte, 5)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("unable to read random bytes. %s", err.Error())
+		}
+		code := strings.ToLower(encoding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+// TOTPPageData is the data to render the TOTP page template.
+type TOTPPageData struct {
+
+	// Action is the URL the form is posted to.
+	Action string
+
+	// Hidden are the hidden form fields (name to value) that
+	// must be posted back with the form.
+	Hidden map[string]string
+
+	// Error is the user understandable message about the
+	// previous attempt, if any.
+	Error string
+
+	// Enroll is true if the user is enrolling a new secret.
+	Enroll bool
+
+	// OTPAuthURI is the otpauth:// URI of the new secret.
+	// Only set on enrollment.
+	OTPAuthURI template.URL
+
+	// Secret is the new secret in base32, to be entered
+	// manually. Only set on enrollment.
+	Secret string
+
+	// RecoveryCodes are the new recovery codes. They are never
+	// shown again. Only set once the enrollment is confirmed,
+	// then the page links to Action to continue instead of the
+	// form.
+	RecoveryCodes []string
+
+	// ClientID is the id of the client requesting authorization.
+	ClientID string
+
+	// Branding of the TOTP page.
+	Branding Branding
+}
+
+// DefaultTOTPTemplate is the template used to render the
+// TOTP page by TOTPHandler if no template is set.
+var DefaultTOTPTemplate = template.Must(template.New("totp").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Two-step verification{{ with .Branding.Name }} - {{ . }}{{ end }}</title>
+{{- with .Branding.StylesheetURL }}
+<link rel="stylesheet" href="{{ . }}">
+{{- end }}
+</head>
+<body>
+{{- with .Branding.LogoURL }}
+<img src="{{ . }}" alt="{{ $.Branding.Name }}">
+{{- end }}
+<h1>Two-step verification</h1>
+{{- with .Error }}
+<p role="alert"><strong>{{ . }}</strong></p>
+{{- end }}
+{{- if .RecoveryCodes }}
+<p>Two-step verification is set up. Keep these recovery codes in a safe place. Each of them can be used once if you lose your device:</p>
+<ul>
+{{- range .RecoveryCodes }}
+<li><code>{{ . }}</code></li>
+{{- end }}
+</ul>
+<p><a href="{{ .Action }}">Continue</a></p>
+{{- else }}
+{{- if .Enroll }}
+<p>Add this account to your authenticator app with <a href="{{ .OTPAuthURI }}">this link</a>, or enter the key manually:</p>
+<p><code>{{ .Secret }}</code></p>
+<p>Then enter the code shown in the app to finish.</p>
+{{- else }}
+<p>Enter the code from your authenticator app, or a recovery code.</p>
+{{- end }}
+<form method="post" action="{{ .Action }}">
+{{- range $name, $value := .Hidden }}
+<input type="hidden" name="{{ $name }}" value="{{ $value }}">
+{{- end }}
+<p><label>Code <input type="text" name="otp" autocomplete="one-time-code" required autofocus></label></p>
+<p><button type="submit">Verify</button></p>
+</form>
+{{- end }}
+{{- with .Branding.SupportURL }}
+<p><a href="{{ . }}">Get help</a></p>
+{{- end }}
+</body>
+</html>`))
+
+// TOTPHandler is an AuthorizeHandler of the multi-factor
+// authentication stage (usually StageIntermediate), with time-based
+// one-time passwords as described in RFC6238.
+//
+// The AuthorizeRequest must have an authenticated user (see
+// RequireUser). The user is asked for a code generated with the
+// secret in Store. A code is accepted within Skew time steps of
+// the current one, but only once: a code of the time step already
+// used, or any before, is refused (RFC6238 section 5.2). A recovery
+// code may be entered instead, and is then removed.
+//
+// If the user has no confirmed secret and Enroll is true, a new
+// secret is generated for the authorization and shown instead. It is
+// carried with the AuthorizeRequest (see OTPEnrollSecret), and only
+// saved in Store once confirmed by a valid code. The recovery codes
+// are then generated and shown once, with their hashes keyed by
+// RecoveryCodeKey saved.
+//
+// On success, the request is moved to stage Next and the user-agent
+// is redirected back to the authorization endpoint. As LoginHandler,
+// the AuthorizeRequest is carried by the PendingAuthorizationStore
+// or StateSealer of the Context, and the form is protected from CSRF.
+//
+// Wrong codes are counted with the AuthorizeRequest (see OTPFailures).
+// Once MaxAttempts is reached, the request is denied with
+// access_denied, and the user has to start over from the login. A
+// sealed request needs the ReplayCache of the Context, where each
+// count is recorded once, so a copy sealed before cannot be replayed
+// to guess again.
+type TOTPHandler struct {
+
+	// Store stores the secrets of users.
+	Store OTPSecretStore
+
+	// Issuer is the name of the service shown in authenticator apps.
+	Issuer string
+
+	// Next is the stage the request is moved to after verification.
+	Next AuthorizeStage
+
+	// Enroll allows users without secret to enroll.
+	Enroll bool
+
+	// Digits is the number of digits of a code.
+	// DefaultTOTPDigits is used if not set.
+	Digits int
+
+	// Period is the time step of the codes.
+	// DefaultTOTPPeriod is used if not set.
+	Period time.Duration
+
+	// Skew is the number of time steps before and after the
+	// current one that a code is still accepted.
+	Skew int
+
+	// RecoveryCodes is the number of recovery codes generated on
+	// confirmation of the enrollment. DefaultRecoveryCodes is used
+	// if not set.
+	RecoveryCodes int
+
+	// MaxAttempts is the number of wrong codes allowed for an
+	// authorization. DefaultTOTPMaxAttempts is used if not set.
+	MaxAttempts int
+
+	// RecoveryCodeKey is the server secret key to hash the
+	// recovery codes with (see HashRecoveryCode). It must be
+	// kept apart from Store. Required.
+	RecoveryCodeKey []byte
+
+	// Template renders the TOTP page with *TOTPPageData.
+	// DefaultTOTPTemplate is used if not set.
+	Template *template.Template
+
+	// Branding is passed to Template with every TOTP page.
+	Branding Branding
+}
+
+// NewTOTPHandler returns a *TOTPHandler that verifies codes against
+// the secrets in store, with recovery codes hashed with recoveryCodeKey,
+// then moves the request to stage next. The default digits, period and
+// skew are used, and enrollment is allowed.
+func NewTOTPHandler(store OTPSecretStore, issuer string, recoveryCodeKey []byte, next AuthorizeStage) *TOTPHandler {
+	return &TOTPHandler{
+		Store:           store,
+		Issuer:          issuer,
+		Next:            next,
+		Enroll:          true,
+		Digits:          DefaultTOTPDigits,
+		Period:          DefaultTOTPPeriod,
+		Skew:            DefaultTOTPSkew,
+		RecoveryCodes:   DefaultRecoveryCodes,
+		MaxAttempts:     DefaultTOTPMaxAttempts,
+		RecoveryCodeKey: recoveryCodeKey,
+	}
+}
+
+// HandleAuthorizeRequest implements AuthorizeHandler
+func (th *TOTPHandler) HandleAuthorizeRequest(ctx context.Context, ar *AuthorizeRequest, decodeErr error) Responder {
+	if decodeErr != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, decodeErr)
+	}
+	actx, err := formStageContext(ctx, ar)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	if ar.UserID == "" {
+		return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrAccessDenied, "user is not authenticated"))
+	}
+	if len(th.RecoveryCodeKey) == 0 {
+		return NewAuthorizeErrorResponse(ctx, ar, fmt.Errorf("recovery code key is required but not set"))
+	}
+	r := ar.HTTPRequest
+
+	secret, err := th.Store.GetOTPSecret(ctx, ar.UserID)
+	if err == ErrOTPSecretNotFound {
+		secret = nil
+	} else if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	enroll := secret == nil || !secret.Confirmed
+	if enroll && !th.Enroll {
+		return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrAccessDenied, "two-step verification is not set up for the user"))
+	}
+
+	// a new secret for each enrollment, so it is only
+	// shown to the user-agent of this authorization
+	if enroll && ar.OTPEnrollSecret == "" {
+		key := make([]byte, 20)
+		if _, err := rand.Read(key); err != nil {
+			return NewAuthorizeErrorResponse(ctx, ar, fmt.Errorf("unable to read random bytes. %s", err.Error()))
+		}
+		ar.OTPEnrollSecret = encodeOTPSecret(key)
+	}
+
+	if r.Method != http.MethodPost {
+		return th.totpPage(ctx, actx, ar, "")
+	}
+	if !validCSRFToken(ar) {
+		return th.totpPage(ctx, actx, ar, "Your session has expired. Please try again.")
+	}
+
+	code := strings.TrimSpace(r.PostFormValue(OTPCodeParam))
+	if enroll {
+		key, err := decodeOTPSecret(ar.OTPEnrollSecret)
+		if err != nil {
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+		counter, ok := th.match(key, code)
+		if !ok {
+			return th.fail(ctx, actx, ar)
+		}
+		return th.confirm(ctx, actx, ar, key, counter)
+	}
+
+	switch err = th.verify(ctx, ar.UserID, secret, code); err {
+	case nil:
+	case ErrOTPCodeUsed:
+		return th.totpPage(ctx, actx, ar, "This code has already been used. Please wait for the next one.")
+	case ErrRecoveryCodeInvalid:
+		return th.fail(ctx, actx, ar)
+	default:
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	return proceedToStage(ctx, actx, ar, th.Next)
+}
+
+// fail counts a wrong code for the AuthorizeRequest. The form is shown
+// again, or the request is denied once MaxAttempts is reached.
+func (th *TOTPHandler) fail(ctx context.Context, actx *Context, ar *AuthorizeRequest) Responder {
+	if ar.SealedStateID != "" {
+		if actx.ReplayCache == nil {
+			return NewAuthorizeErrorResponse(ctx, ar, fmt.Errorf("replay cache is required but not set in context"))
+		}
+
+		// the ids are base64url, so "/" does not clash with another id
+		switch err := actx.ReplayCache.UseOnce(ctx, ar.SealedStateID+"/otp/"+strconv.Itoa(ar.OTPFailures)); err {
+		case nil:
+		case ErrSealedStateReplayed:
+			return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has expired"))
+		default:
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+	}
+	ar.OTPFailures++
+
+	max := th.MaxAttempts
+	if max == 0 {
+		max = DefaultTOTPMaxAttempts
+	}
+	if ar.OTPFailures < max {
+		return th.totpPage(ctx, actx, ar, "Invalid code.")
+	}
+
+	// end the authorization, so no code can be tried for it
+	if actx.PendingAuthorizationStore != nil && ar.PendingHandle != "" {
+		switch err := actx.DeletePending(ctx, ar.PendingHandle); err {
+		case nil:
+		case ErrPendingNotFound:
+			return NewAuthorizeErrorResponse(ctx, ar, NewError(ErrInvalidRequest, "authorization session has expired"))
+		default:
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+	}
+	if ar.SealedStateID != "" {
+		if err := actx.ReplayCache.UseOnce(ctx, ar.SealedStateID); err != nil && err != ErrSealedStateReplayed {
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+	}
+	rspr := NewAuthorizeErrorResponse(ctx, ar, NewError(ErrAccessDenied, "too many failed verification attempts"))
+	if rr, ok := rspr.(*RedirectResponse); ok {
+		rr.Code = http.StatusSeeOther
+		clearCarrier(ar.HTTPRequest, rr.HeaderCache)
+	}
+	return rspr
+}
+
+// confirm saves the enrolling secret of the user, confirmed at the
+// counter, with new recovery codes, and moves the request to stage
+// Next. The codes are shown once on the page, which links back to the
+// authorization endpoint.
+func (th *TOTPHandler) confirm(ctx context.Context, actx *Context, ar *AuthorizeRequest, key []byte, counter uint64) Responder {
+	n := th.RecoveryCodes
+	if n == 0 {
+		n = DefaultRecoveryCodes
+	}
+	codes, err := newRecoveryCodes(n)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+
+	secret := &OTPSecret{
+		Secret:      key,
+		Confirmed:   true,
+		LastCounter: counter,
+	}
+	for _, code := range codes {
+		secret.RecoveryCodeHashes = append(secret.RecoveryCodeHashes, HashRecoveryCode(th.RecoveryCodeKey, ar.UserID, code))
+	}
+	if err = th.Store.SaveOTPSecret(ctx, ar.UserID, secret); err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	ar.OTPEnrollSecret = ""
+
+	carrier, rspr := advanceStage(ctx, actx, ar, th.Next)
+	if rspr != nil {
+		return rspr
+	}
+	tmpl := th.Template
+	if tmpl == nil {
+		tmpl = DefaultTOTPTemplate
+	}
+	rsp, err := renderFormPage(ar, tmpl, &TOTPPageData{
+		Action:        ar.HTTPRequest.URL.Path,
+		Hidden:        make(map[string]string),
+		RecoveryCodes: codes,
+		ClientID:      ar.ClientID,
+		Branding:      th.Branding,
+	}, make(map[string]string), carrier)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	return rsp
+}
+
+// verify verifies the code as a TOTP value of the confirmed secret,
+// or else as a recovery code.
+func (th *TOTPHandler) verify(ctx context.Context, userID string, secret *OTPSecret, code string) error {
+	if counter, ok := th.match(secret.Secret, code); ok {
+		return th.Store.UseOTPCounter(ctx, userID, counter)
+	}
+	if code == "" {
+		return ErrRecoveryCodeInvalid
+	}
+	return th.Store.UseRecoveryCode(ctx, userID, HashRecoveryCode(th.RecoveryCodeKey, userID, code))
+}
+
+// match returns the time step within Skew of the current one at
+// which the code is the TOTP value of the secret, if any.
+func (th *TOTPHandler) match(secret []byte, code string) (uint64, bool) {
+	digits := th.digits()
+	if len(code) != digits {
+		return 0, false
+	}
+	now := totpCounter(time.Now(), th.period())
+	for i := -th.Skew; i <= th.Skew; i++ {
+		counter := now + uint64(i)
+		expected := generateHOTP(secret, counter, digits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// totpPage renders the TOTP form with the AuthorizeRequest carried
+// in the cookie, and its changes saved. The secret is shown if the
+// user is enrolling.
+func (th *TOTPHandler) totpPage(ctx context.Context, actx *Context, ar *AuthorizeRequest, message string) Responder {
+	carrier, rspr := saveRequest(ctx, actx, ar)
+	if rspr != nil {
+		return rspr
+	}
+	hidden := make(map[string]string)
+
+	data := &TOTPPageData{
+		Action:   ar.HTTPRequest.URL.Path,
+		Hidden:   hidden,
+		Error:    message,
+		ClientID: ar.ClientID,
+		Branding: th.Branding,
+	}
+	if ar.OTPEnrollSecret != "" {
+		key, err := decodeOTPSecret(ar.OTPEnrollSecret)
+		if err != nil {
+			return NewAuthorizeErrorResponse(ctx, ar, err)
+		}
+		data.Enroll = true
+		data.Secret = ar.OTPEnrollSecret
+		data.OTPAuthURI = template.URL(OTPAuthURI(th.Issuer, ar.UserID, key, th.digits(), th.period()))
+	}
+
+	tmpl := th.Template
+	if tmpl == nil {
+		tmpl = DefaultTOTPTemplate
+	}
+	rsp, err := renderFormPage(ar, tmpl, data, hidden, carrier)
+	if err != nil {
+		return NewAuthorizeErrorResponse(ctx, ar, err)
+	}
+	return rsp
+}
+
+func (th *TOTPHandler) digits() int {
+	if th.Digits == 0 {
+		return DefaultTOTPDigits
+	}
+	return th.Digits
+}
+
+func (th *TOTPHandler) period() time.Duration {
+	if th.Period < time.Second {
+		return DefaultTOTPPeriod
+	}
+	return th.Period
+}
+
+// MemoryOTPSecretStore is an in-memory OTPSecretStore
+// implementation, mainly intended for testing.
+type MemoryOTPSecretStore struct {
+	mutex   sync.Mutex
+	secrets map[string]*OTPSecret
+}
+
+// NewMemoryOTPSecretStore returns an initialized
+// *MemoryOTPSecretStore.
+func NewMemoryOTPSecretStore() *MemoryOTPSecretStore {
+	return &MemoryOTPSecretStore{
+		secrets: make(map[string]*OTPSecret),
+	}
+}
+
+// GetOTPSecret implements OTPSecretStore
+func (store *MemoryOTPSecretStore) GetOTPSecret(ctx context.Context, userID string) (*OTPSecret, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored, ok := store.secrets[userID]
+	if !ok {
+		return nil, ErrOTPSecretNotFound
+	}
+	return copyOTPSecret(stored), nil
+}
+
+// SaveOTPSecret implements OTPSecretStore
+func (store *MemoryOTPSecretStore) SaveOTPSecret(ctx context.Context, userID string, secret *OTPSecret) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.secrets[userID] = copyOTPSecret(secret)
+	return nil
+}
+
+// UseOTPCounter implements OTPSecretStore
+func (store *MemoryOTPSecretStore) UseOTPCounter(ctx context.Context, userID string, counter uint64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored, ok := store.secrets[userID]
+	if !ok {
+		return ErrOTPSecretNotFound
+	}
+	if counter <= stored.LastCounter {
+		return ErrOTPCodeUsed
+	}
+	stored.LastCounter = counter
+	return nil
+}
+
+// UseRecoveryCode implements OTPSecretStore
+func (store *MemoryOTPSecretStore) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored, ok := store.secrets[userID]
+	if !ok {
+		return ErrRecoveryCodeInvalid
+	}
+	for i, candidate := range stored.RecoveryCodeHashes {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+			hashes := stored.RecoveryCodeHashes
+			stored.RecoveryCodeHashes = append(hashes[:i:i], hashes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRecoveryCodeInvalid
+}
+
+func copyOTPSecret(secret *OTPSecret) *OTPSecret {
+	copied := *secret
+	copied.Secret = append([]byte(nil), secret.Secret...)
+	copied.RecoveryCodeHashes = append([]string(nil), secret.RecoveryCodeHashes...)
+	return &copied
+}
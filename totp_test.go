@@ -0,0 +1,290 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	// test vectors of RFC6238 appendix B, SHA1 mode
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, test := range tests {
+		if want, have := test.expected, oasis.GenerateTOTP(secret, time.Unix(test.unix, 0), 8, 30*time.Second); want != have {
+			t.Errorf("T=%d: expected %#v, got %#v", test.unix, want, have)
+		}
+	}
+}
+
+func TestOTPAuthURI(t *testing.T) {
+	uri := oasis.OTPAuthURI("Foo Bar", "dummy@foobar.com", []byte("12345678901234567890"), 6, 30*time.Second)
+	expected := "otpauth://totp/Foo%20Bar:dummy@foobar.com?algorithm=SHA1&digits=6&issuer=Foo+Bar&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if want, have := expected, uri; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestTOTPHandler(t *testing.T) {
+	pending := oasis.NewMemoryPendingAuthorizationStore(time.Minute)
+	secrets := oasis.NewMemoryOTPSecretStore()
+	recoveryCodeKey := []byte("recovery-code-key")
+	actx := oasis.Context{
+		ClientStore:               newTestClientStore(),
+		PendingAuthorizationStore: pending,
+	}
+
+	var passed int
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageIntermediate, oasis.NewTOTPHandler(secrets, "Foo Bar", recoveryCodeKey, oasis.StageToAuthorize))
+	mux.AddFunc(oasis.StageToAuthorize, func(ctx context.Context, ar *oasis.AuthorizeRequest, decodeErr error) oasis.Responder {
+		passed++
+		return &oasis.ResponseCache{Code: http.StatusOK}
+	})
+	endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+
+	// start returns the page of a new pending request
+	// at the TOTP stage, its hidden fields and cookies
+	start := func() (string, url.Values, []*http.Cookie) {
+		handle, _ := pending.CreatePending(context.Background(), &oasis.AuthorizeRequest{
+			ResponseType: "code",
+			ClientID:     "web-client",
+			RedirectURI:  "https://web.foobar.com/cb",
+			Stage:        oasis.StageIntermediate,
+			UserID:       "dummy-user",
+		})
+		r := httptest.NewRequest("GET", "/authorize", nil)
+		r.AddCookie(&http.Cookie{Name: oasis.PendingHandleParam, Value: handle})
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		if want, have := http.StatusOK, w.Code; want != have {
+			t.Fatalf("expected %#v, got %#v", want, have)
+		}
+		return w.Body.String(), hiddenFields(w.Body.String()), w.Result().Cookies()
+	}
+	post := func(form url.Values, cookies []*http.Cookie, code string) *httptest.ResponseRecorder {
+		form.Set(oasis.OTPCodeParam, code)
+		r := httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		return w
+	}
+	follow := func(w *httptest.ResponseRecorder) {
+		if want, have := http.StatusSeeOther, w.Code; want != have {
+			t.Fatalf("expected %#v, got %#v: %s", want, have, w.Body.String())
+		}
+		r := httptest.NewRequest("GET", w.Header().Get("Location"), nil)
+		r.AddCookie(responseCookie(w, oasis.PendingHandleParam))
+		endpoint.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// an unconfirmed secret in the store is not shown
+	unconfirmed := &oasis.OTPSecret{Secret: []byte("12345678901234567890")}
+	secrets.SaveOTPSecret(context.Background(), "dummy-user", unconfirmed)
+	if page, _, _ := start(); strings.Contains(page, "GEZDGNBVGY3TQOJQ") {
+		t.Errorf("unexpected unconfirmed secret in the page %s", page)
+	}
+
+	// enrollment
+	page, form, cookie := start()
+	if !strings.Contains(page, `href="otpauth://totp/`) {
+		t.Errorf("expected otpauth URI in the page, got %s", page)
+	}
+	encoded := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(page)
+	if encoded == nil {
+		t.Fatalf("expected secret in the page, got %s", page)
+	}
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded[1])
+	recoveryCode := regexp.MustCompile(`<li><code>([a-z2-7-]+)</code></li>`)
+	if matches := recoveryCode.FindAllStringSubmatch(page, -1); len(matches) != 0 {
+		t.Errorf("unexpected recovery codes before confirmation %#v", matches)
+	}
+
+	// the page of the authorization is rendered again with the
+	// same secret, but another authorization gets a new one
+	r := httptest.NewRequest("GET", "/authorize", nil)
+	for _, c := range cookie {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), encoded[1]) {
+		t.Errorf("expected the same secret in the page, got %s", w.Body.String())
+	}
+	if other, _, _ := start(); strings.Contains(other, encoded[1]) {
+		t.Errorf("expected a new secret in the page, got %s", other)
+	}
+
+	// nothing is saved before confirmation
+	if stored, _ := secrets.GetOTPSecret(context.Background(), "dummy-user"); string(stored.Secret) != string(unconfirmed.Secret) {
+		t.Errorf("unexpected enrollment %#v", stored)
+	}
+
+	// confirmation shows the recovery codes once
+	code := oasis.GenerateTOTP(secret, time.Now(), oasis.DefaultTOTPDigits, oasis.DefaultTOTPPeriod)
+	w = post(form, cookie, code)
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	recoveryCodes := recoveryCode.FindAllStringSubmatch(w.Body.String(), -1)
+	if want, have := oasis.DefaultRecoveryCodes, len(recoveryCodes); want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if !strings.Contains(w.Body.String(), `<a href="/authorize">Continue</a>`) {
+		t.Errorf("expected link to continue, got %s", w.Body.String())
+	}
+	r = httptest.NewRequest("GET", "/authorize", nil)
+	r.AddCookie(responseCookie(w, oasis.PendingHandleParam))
+	endpoint.ServeHTTP(httptest.NewRecorder(), r)
+	if want, have := 1, passed; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	stored, _ := secrets.GetOTPSecret(context.Background(), "dummy-user")
+	if !stored.Confirmed {
+		t.Errorf("expected secret to be confirmed")
+	}
+
+	// the hashes are keyed, and specific to the user
+	if want, have := oasis.HashRecoveryCode(recoveryCodeKey, "dummy-user", recoveryCodes[0][1]), stored.RecoveryCodeHashes[0]; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	for _, hash := range []string{
+		oasis.HashRecoveryCode([]byte("other-key"), "dummy-user", recoveryCodes[0][1]),
+		oasis.HashRecoveryCode(recoveryCodeKey, "other-user", recoveryCodes[0][1]),
+	} {
+		if hash == stored.RecoveryCodeHashes[0] {
+			t.Errorf("unexpected hash collision %#v", hash)
+		}
+	}
+
+	// the same code is refused
+	page, form, cookie = start()
+	if strings.Contains(page, "otpauth://") {
+		t.Errorf("unexpected enrollment for confirmed secret")
+	}
+	if w := post(form, cookie, code); !strings.Contains(w.Body.String(), "This code has already been used.") {
+		t.Errorf("expected replay error, got %s", w.Body.String())
+	}
+
+	// wrong code
+	if w := post(form, cookie, "000000"); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "already") {
+		t.Errorf("expected the form again, got %#v", w.Code)
+	}
+
+	// recovery code, only once
+	follow(post(form, cookie, strings.ToUpper(recoveryCodes[0][1])))
+	if want, have := 2, passed; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+	_, form, cookie = start()
+	if w := post(form, cookie, recoveryCodes[0][1]); !strings.Contains(w.Body.String(), "Invalid code.") {
+		t.Errorf("expected invalid code error, got %s", w.Body.String())
+	}
+	stored, _ = secrets.GetOTPSecret(context.Background(), "dummy-user")
+	if want, have := oasis.DefaultRecoveryCodes-1, len(stored.RecoveryCodeHashes); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	// denied after too many wrong codes
+	_, form, cookie = start()
+	for i := 1; i < oasis.DefaultTOTPMaxAttempts; i++ {
+		if w := post(form, cookie, "invalid"); !strings.Contains(w.Body.String(), "Invalid code.") {
+			t.Fatalf("attempt %d: expected invalid code error, got %s", i, w.Body.String())
+		}
+	}
+	w = post(form, cookie, "invalid")
+	if location := w.Header().Get("Location"); !strings.Contains(location, "error=access_denied") {
+		t.Errorf("expected access_denied redirection, got %#v", location)
+	}
+	for _, c := range cookie {
+		if c.Name != oasis.PendingHandleParam {
+			continue
+		}
+		if _, err := pending.GetPending(context.Background(), c.Value); err != oasis.ErrPendingNotFound {
+			t.Errorf("expected ErrPendingNotFound, got %#v", err)
+		}
+	}
+	if want, have := 2, passed; want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+}
+
+func TestTOTPHandler_sealedAttempts(t *testing.T) {
+	sealer, _ := oasis.NewAESStateSealer(time.Minute, bytes.Repeat([]byte("k"), 32))
+	secrets := oasis.NewMemoryOTPSecretStore()
+	secrets.SaveOTPSecret(context.Background(), "dummy-user", &oasis.OTPSecret{
+		Secret:    []byte("12345678901234567890"),
+		Confirmed: true,
+	})
+	actx := oasis.Context{
+		ClientStore: newTestClientStore(),
+		StateSealer: sealer,
+		ReplayCache: oasis.NewMemoryReplayCache(time.Minute),
+	}
+	handler := oasis.NewTOTPHandler(secrets, "Foo Bar", []byte("recovery-code-key"), oasis.StageToAuthorize)
+	handler.MaxAttempts = 2
+	mux := oasis.NewAuthorizeHandlerMux()
+	mux.Add(oasis.StageIntermediate, handler)
+	endpoint := oasis.NewAuthorizeEndpoint(actx, oasis.NewAuthorizeDecoder("code"), mux, oasis.NewResponseEncoder())
+
+	sealed, _ := sealer.Seal(&oasis.AuthorizeRequest{
+		ResponseType: "code",
+		ClientID:     "web-client",
+		RedirectURI:  "https://web.foobar.com/cb",
+		Stage:        oasis.StageIntermediate,
+		UserID:       "dummy-user",
+	})
+	r := httptest.NewRequest("GET", "/authorize", nil)
+	r.AddCookie(&http.Cookie{Name: oasis.SealedStateParam, Value: sealed})
+	page := httptest.NewRecorder()
+	endpoint.ServeHTTP(page, r)
+	first := responseCookie(page, oasis.SealedStateParam)
+	post := func(carrier *http.Cookie) *httptest.ResponseRecorder {
+		form := hiddenFields(page.Body.String())
+		form.Set(oasis.OTPCodeParam, "invalid")
+		r := httptest.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(carrier)
+		r.AddCookie(responseCookie(page, oasis.CSRFTokenParam))
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post(first)
+	if !strings.Contains(w.Body.String(), "Invalid code.") {
+		t.Fatalf("expected invalid code error, got %s", w.Body.String())
+	}
+	second := responseCookie(w, oasis.SealedStateParam)
+
+	// the count is not reset by replaying the cookie of before
+	if location := post(first).Header().Get("Location"); !strings.Contains(location, "error=invalid_request") {
+		t.Errorf("expected invalid_request redirection, got %#v", location)
+	}
+	if location := post(second).Header().Get("Location"); !strings.Contains(location, "error=access_denied") {
+		t.Errorf("expected access_denied redirection, got %#v", location)
+	}
+}
@@ -0,0 +1,197 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// NewBearerErrorResponse returns an *ErrorResponse of the error,
+// with the "WWW-Authenticate" challenge of the Bearer scheme as
+// described in RFC6750 section 3. An error that is not an *Error
+// is reported as server_error.
+//
+// If the request has no access token at all, err should be nil,
+// so the challenge does not include any error code (RFC6750
+// section 3.1).
+func NewBearerErrorResponse(err error) *ErrorResponse {
+	header := make(http.Header)
+	if err == nil {
+		header.Set("WWW-Authenticate", "Bearer")
+		return &ErrorResponse{
+			HeaderCache: header,
+			Err: &Error{
+				ErrorCode:   ErrInvalidRequest,
+				Description: "access token is required but not set",
+				StatusCode:  http.StatusUnauthorized,
+			},
+		}
+	}
+
+	oerr, ok := err.(*Error)
+	if !ok {
+		oerr = NewError(ErrServerError, "")
+	}
+	challenge := fmt.Sprintf(`Bearer error="%s"`, oerr.ErrorCode)
+	if oerr.Description != "" {
+		challenge += fmt.Sprintf(`, error_description="%s"`, strings.ReplaceAll(oerr.Description, `"`, `'`))
+	}
+	header.Set("WWW-Authenticate", challenge)
+	return &ErrorResponse{
+		HeaderCache: header,
+		Err:         oerr,
+	}
+}
+
+// bearerToken returns the access token of the request, either in
+// the "Authorization" header (RFC6750 section 2.1) or in the form
+// encoded body (RFC6750 section 2.2).
+func bearerToken(r *http.Request) (string, *Error) {
+	var tokens []string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", NewError(ErrInvalidRequest, "authorization header is not a bearer token")
+		}
+		tokens = append(tokens, strings.TrimSpace(token))
+	}
+	if r.Method == http.MethodPost {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+			if err := r.ParseForm(); err != nil {
+				return "", NewError(ErrInvalidRequest, "unable to parse request body")
+			}
+			tokens = append(tokens, r.PostForm["access_token"]...)
+		}
+	}
+
+	switch len(tokens) {
+	case 0:
+		return "", nil
+	case 1:
+		return tokens[0], nil
+	}
+	return "", NewError(ErrInvalidRequest, "access token must not be included more than once")
+}
+
+// UserInfoResponse is the successful UserInfo Response as described
+// in OpenID Connect Core 1.0 section 5.3.2. The claims are output as
+// JSON, or as a signed JWT if Signer is set.
+type UserInfoResponse struct {
+
+	// HeaderCache stores the response http header
+	HeaderCache http.Header
+
+	// Claims are the claims about the user.
+	Claims Claims
+
+	// Signer, if set, signs the claims as a JWT.
+	Signer JWTSigner
+}
+
+// ResponseTo implements Responder interface
+func (rsp *UserInfoResponse) ResponseTo(w http.ResponseWriter) error {
+	var signed string
+	if rsp.Signer != nil {
+		var err error
+		if signed, err = rsp.Signer.SignJWT("", rsp.Claims); err != nil {
+			return NewError(ErrServerError, "unable to sign userinfo")
+		}
+	}
+
+	for key, values := range rsp.HeaderCache {
+		for i := range values {
+			w.Header().Add(key, values[i])
+		}
+	}
+	if rsp.Signer == nil {
+		return writeJSON(w, http.StatusOK, rsp.Claims)
+	}
+	w.Header().Set("Content-Type", "application/jwt")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(signed))
+	return err
+}
+
+// handleUserInfo returns the UserInfo Response of the
+// access token of the request.
+func handleUserInfo(ctx context.Context, actx *Context, r *http.Request) (Responder, *Token) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return NewBearerErrorResponse(&Error{
+			ErrorCode:   ErrInvalidRequest,
+			Description: "userinfo request must use GET or POST method",
+			StatusCode:  http.StatusMethodNotAllowed,
+		}), nil
+	}
+	if actx.TokenStorage == nil {
+		return NewBearerErrorResponse(NewError(ErrServerError, "token storage is not set")), nil
+	}
+
+	value, oerr := bearerToken(r)
+	if oerr != nil {
+		return NewBearerErrorResponse(oerr), nil
+	}
+	if value == "" {
+		return NewBearerErrorResponse(nil), nil
+	}
+
+	token, err := actx.GetToken(ctx, TokenTypeAccessToken, value)
+	if err == ErrTokenNotFound {
+		return NewBearerErrorResponse(NewError(ErrInvalidToken, "access token is invalid or expired")), nil
+	} else if err != nil {
+		return NewBearerErrorResponse(NewError(ErrServerError, "unable to retrieve access token")), nil
+	}
+	if token.UserID == "" {
+		return NewBearerErrorResponse(NewError(ErrInvalidToken, "access token is not authorized by a user")), token
+	}
+	scope, _ := ParseScope(token.Scope)
+	if !scope.Contains(ScopeOpenID) {
+		return NewBearerErrorResponse(NewError(ErrInsufficientScope, "openid scope is required")), token
+	}
+
+	claims, err := scopeClaims(ctx, actx, token.UserID, scope)
+	if err != nil {
+		return NewBearerErrorResponse(NewError(ErrServerError, "unable to retrieve claims")), token
+	}
+	claims["sub"] = token.UserID
+	rsp := &UserInfoResponse{
+		HeaderCache: make(http.Header),
+		Claims:      claims,
+		Signer:      actx.UserInfoSigner,
+	}
+	if rsp.Signer != nil {
+		claims["iss"] = actx.Issuer
+		claims["aud"] = token.ClientID
+	}
+	return rsp, token
+}
+
+// NewUserInfoEndpoint returns an http.Handler to handle the
+// UserInfo Endpoint as described in OpenID Connect Core 1.0
+// section 5.3.
+//
+// The bearer access token (RFC6750) is looked up in the
+// TokenStorage, and must be granted the openid scope. The
+// claims requested by the granted scope (see ScopeClaims) are
+// returned from the Context.ClaimsProvider, with "sub" set to
+// the user of the token. If Context.UserInfoSigner is set, the
+// claims are returned as a signed JWT instead of JSON.
+func NewUserInfoEndpoint(actx Context, encoder ResponseEncoder) http.Handler {
+	actx = actx.withDefaults()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithContext(r.Context(), &actx)
+		rspr, token := handleUserInfo(ctx, &actx, r)
+		var attrs []slog.Attr
+		if token != nil {
+			attrs = append(attrs,
+				slog.String("client_id", token.ClientID),
+				slog.String("user_id", token.UserID),
+			)
+		}
+		encodeAndLog(ctx, actx.Logger, "userinfo request", w, r, encoder, rspr, nil, attrs...)
+	})
+}
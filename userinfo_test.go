@@ -0,0 +1,192 @@
+package oasis_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oasis/oasis"
+)
+
+func newUserInfoContext(t *testing.T) oasis.Context {
+	storage := oasis.NewMemoryTokenStorage()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+	tokens := []*oasis.Token{
+		{Value: "openid-token", ClientID: "web-client", UserID: "dummy-user", Scope: "openid email"},
+		{Value: "plain-token", ClientID: "web-client", UserID: "dummy-user", Scope: "email"},
+		{Value: "client-token", ClientID: "web-client", Scope: "openid"},
+	}
+	for _, token := range tokens {
+		token.Type = oasis.TokenTypeAccessToken
+		token.ExpiresAt = expiresAt
+		if err := storage.SaveToken(ctx, token); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	return oasis.Context{
+		TokenStorage: storage,
+		Issuer:       "https://foobar.com",
+		ClaimsProvider: oasis.ClaimsProviderFunc(func(ctx context.Context, userID string, names []string) (oasis.Claims, error) {
+			return oasis.Claims{
+				"email":        "dummy@foobar.com",
+				"phone_number": "+1 555 0100",
+				"sub":          "spoofed-user",
+			}, nil
+		}),
+	}
+}
+
+func TestUserInfoEndpoint(t *testing.T) {
+	endpoint := oasis.NewUserInfoEndpoint(newUserInfoContext(t), oasis.NewResponseEncoder())
+
+	tests := []struct {
+		name              string
+		r                 *http.Request
+		expectedCode      int
+		expectedChallenge string
+		expectedBody      string
+	}{
+		{
+			name:              "no token",
+			r:                 httptest.NewRequest("GET", "/userinfo", nil),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: "Bearer",
+		},
+		{
+			name: "unknown token",
+			r: func() *http.Request {
+				r := httptest.NewRequest("GET", "/userinfo", nil)
+				r.Header.Set("Authorization", "Bearer unknown-token")
+				return r
+			}(),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `Bearer error="invalid_token", error_description="access token is invalid or expired"`,
+		},
+		{
+			name: "not a bearer token",
+			r: func() *http.Request {
+				r := httptest.NewRequest("GET", "/userinfo", nil)
+				r.SetBasicAuth("web-client", "secret")
+				return r
+			}(),
+			expectedCode:      http.StatusBadRequest,
+			expectedChallenge: `Bearer error="invalid_request", error_description="authorization header is not a bearer token"`,
+		},
+		{
+			name: "token without user",
+			r: func() *http.Request {
+				r := httptest.NewRequest("GET", "/userinfo", nil)
+				r.Header.Set("Authorization", "Bearer client-token")
+				return r
+			}(),
+			expectedCode:      http.StatusUnauthorized,
+			expectedChallenge: `Bearer error="invalid_token", error_description="access token is not authorized by a user"`,
+		},
+		{
+			name: "token without openid scope",
+			r: func() *http.Request {
+				r := httptest.NewRequest("GET", "/userinfo", nil)
+				r.Header.Set("Authorization", "Bearer plain-token")
+				return r
+			}(),
+			expectedCode:      http.StatusForbidden,
+			expectedChallenge: `Bearer error="insufficient_scope", error_description="openid scope is required"`,
+		},
+		{
+			name: "token in header and body",
+			r: func() *http.Request {
+				r := httptest.NewRequest("POST", "/userinfo", strings.NewReader("access_token=openid-token"))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				r.Header.Set("Authorization", "Bearer openid-token")
+				return r
+			}(),
+			expectedCode:      http.StatusBadRequest,
+			expectedChallenge: `Bearer error="invalid_request", error_description="access token must not be included more than once"`,
+		},
+		{
+			name:         "unsupported method",
+			r:            httptest.NewRequest("DELETE", "/userinfo", nil),
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name: "token in header",
+			r: func() *http.Request {
+				r := httptest.NewRequest("GET", "/userinfo", nil)
+				r.Header.Set("Authorization", "Bearer openid-token")
+				return r
+			}(),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"email":"dummy@foobar.com","sub":"dummy-user"}`,
+		},
+		{
+			name: "token in body",
+			r: func() *http.Request {
+				r := httptest.NewRequest("POST", "/userinfo", strings.NewReader(url.Values{"access_token": {"openid-token"}}.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			}(),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"email":"dummy@foobar.com","sub":"dummy-user"}`,
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		endpoint.ServeHTTP(w, test.r)
+		if want, have := test.expectedCode, w.Code; want != have {
+			t.Errorf("%s: expected %#v, got %#v", test.name, want, have)
+		}
+		if test.expectedChallenge != "" {
+			if want, have := test.expectedChallenge, w.Header().Get("WWW-Authenticate"); want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.name, want, have)
+			}
+		}
+		if test.expectedBody != "" {
+			if want, have := test.expectedBody, strings.TrimSpace(w.Body.String()); want != have {
+				t.Errorf("%s: expected %#v, got %#v", test.name, want, have)
+			}
+		}
+	}
+}
+
+func TestUserInfoEndpoint_signed(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	signer, _ := oasis.NewSigningKey("dummy-key", key)
+	actx := newUserInfoContext(t)
+	actx.UserInfoSigner = signer
+	endpoint := oasis.NewUserInfoEndpoint(actx, oasis.NewResponseEncoder())
+
+	r := httptest.NewRequest("GET", "/userinfo", nil)
+	r.Header.Set("Authorization", "Bearer openid-token")
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, r)
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("expected %#v, got %#v", want, have)
+	}
+	if want, have := "application/jwt", w.Header().Get("Content-Type"); want != have {
+		t.Errorf("expected %#v, got %#v", want, have)
+	}
+
+	_, claims := verifyJWT(t, w.Body.String(), key)
+	expected := map[string]interface{}{
+		"iss":   "https://foobar.com",
+		"aud":   "web-client",
+		"sub":   "dummy-user",
+		"email": "dummy@foobar.com",
+	}
+	if want, have := mustJSON(expected), mustJSON(claims); want != have {
+		t.Errorf("expected %s, got %s", want, have)
+	}
+}
+
+func mustJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}